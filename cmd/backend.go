@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/discovery"
+)
+
+// the defaults describe the GitLab managed terraform state API
+const (
+	defaultBackendAddress       = "{{.API}}/projects/{{.ProjectID}}/terraform/state/{{.StateName}}"
+	defaultBackendLockAddress   = "{{.Address}}/lock"
+	defaultBackendUnlockAddress = "{{.Address}}/unlock"
+	defaultBackendLockMethod    = "POST"
+	defaultBackendUnlockMethod  = "DELETE"
+	defaultBackendRetryMax      = 2
+	defaultBackendRetryWaitMin  = 5
+	defaultBackendRetryWaitMax  = 30
+)
+
+// httpBackend is the rendered http_backend section of config.yaml, shared by the terraform CLI
+// through its TF_HTTP_* environment and by --tf-source http
+type httpBackend struct {
+	StateName            string
+	Address              string
+	LockAddress          string
+	UnlockAddress        string
+	LockMethod           string
+	UnlockMethod         string
+	Username             string
+	Password             string
+	RetryMax             int
+	RetryWaitMin         int
+	RetryWaitMax         int
+	SkipCertVerification bool
+}
+
+type backendVars struct {
+	API       string
+	ProjectID int
+	StateName string
+	Address   string
+}
+
+func (c *config) httpBackend() (httpBackend, error) {
+	section := c.sections.HTTPBackend
+	backend := httpBackend{
+		StateName:            c.terraformStateName(),
+		LockMethod:           valueOr(section.LockMethod, defaultBackendLockMethod),
+		UnlockMethod:         valueOr(section.UnlockMethod, defaultBackendUnlockMethod),
+		Username:             valueOr(section.Username, *c.user),
+		Password:             *c.accessToken,
+		RetryMax:             defaultBackendRetryMax,
+		RetryWaitMin:         defaultBackendRetryWaitMin,
+		RetryWaitMax:         defaultBackendRetryWaitMax,
+		SkipCertVerification: section.SkipCertVerification,
+	}
+	if section.RetryMax != nil {
+		backend.RetryMax = *section.RetryMax
+	}
+	if section.RetryWaitMin > 0 {
+		backend.RetryWaitMin = section.RetryWaitMin
+	}
+	if section.RetryWaitMax > 0 {
+		backend.RetryWaitMax = section.RetryWaitMax
+	}
+	vars := backendVars{API: *c.api, ProjectID: *c.projectId, StateName: backend.StateName}
+	var renderErr error
+	if backend.Address, renderErr = renderBackend("address", valueOr(section.Address, defaultBackendAddress), vars); renderErr != nil {
+		return backend, renderErr
+	}
+	vars.Address = backend.Address
+	if backend.LockAddress, renderErr = renderBackend("lock_address", valueOr(section.LockAddress, defaultBackendLockAddress), vars); renderErr != nil {
+		return backend, renderErr
+	}
+	if backend.UnlockAddress, renderErr = renderBackend("unlock_address", valueOr(section.UnlockAddress, defaultBackendUnlockAddress), vars); renderErr != nil {
+		return backend, renderErr
+	}
+	return backend, nil
+}
+
+func renderBackend(name, text string, vars backendVars) (string, error) {
+	tmpl, parseErr := template.New(name).Option("missingkey=error").Parse(text)
+	if parseErr != nil {
+		return "", fmt.Errorf("invalid http_backend %s in config.yaml: %w", name, parseErr)
+	}
+	var out bytes.Buffer
+	if execErr := tmpl.Execute(&out, vars); execErr != nil {
+		return "", fmt.Errorf("invalid http_backend %s in config.yaml: %w", name, execErr)
+	}
+	return out.String(), nil
+}
+
+func valueOr(value, fallback string) string {
+	if len(value) == 0 {
+		return fallback
+	}
+	return value
+}
+
+// env is the environment that points the terraform CLI at the backend
+func (b httpBackend) env() []string {
+	env := []string{
+		fmt.Sprintf("%s=%s", "TF_STATE_NAME", b.StateName),
+		fmt.Sprintf("%s=%s", "TF_HTTP_USERNAME", b.Username),
+		fmt.Sprintf("%s=%s", "TF_HTTP_PASSWORD", b.Password),
+		fmt.Sprintf("%s=%s", "TF_HTTP_ADDRESS", b.Address),
+		fmt.Sprintf("%s=%s", "TF_HTTP_LOCK_ADDRESS", b.LockAddress),
+		fmt.Sprintf("%s=%s", "TF_HTTP_UNLOCK_ADDRESS", b.UnlockAddress),
+		fmt.Sprintf("%s=%s", "TF_HTTP_LOCK_METHOD", b.LockMethod),
+		fmt.Sprintf("%s=%s", "TF_HTTP_UNLOCK_METHOD", b.UnlockMethod),
+		fmt.Sprintf("%s=%d", "TF_HTTP_RETRY_MAX", b.RetryMax),
+		fmt.Sprintf("%s=%d", "TF_HTTP_RETRY_WAIT_MIN", b.RetryWaitMin),
+		fmt.Sprintf("%s=%d", "TF_HTTP_RETRY_WAIT_MAX", b.RetryWaitMax),
+	}
+	if b.SkipCertVerification {
+		env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_SKIP_CERT_VERIFICATION", strconv.FormatBool(b.SkipCertVerification)))
+	}
+	return env
+}
+
+// state is the backend as --tf-source http reads it
+func (b httpBackend) state() discovery.HTTPBackend {
+	return discovery.HTTPBackend{
+		Address:              b.Address,
+		Username:             b.Username,
+		Password:             b.Password,
+		SkipCertVerification: b.SkipCertVerification,
+		RetryMax:             b.RetryMax,
+		RetryWaitMin:         time.Duration(b.RetryWaitMin) * time.Second,
+		RetryWaitMax:         time.Duration(b.RetryWaitMax) * time.Second,
+	}
+}
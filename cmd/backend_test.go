@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backendConfig is a config over a terraform directory named app in the staging workspace
+func backendConfig(t *testing.T) *config {
+	t.Helper()
+	tfDir := filepath.Join(t.TempDir(), "app")
+	if mkdirErr := os.Mkdir(tfDir, 0700); mkdirErr != nil {
+		t.Fatal(mkdirErr)
+	}
+	c := testConfig()
+	c.tfDir = ptr(tfDir)
+	c.workspace = ptr("staging")
+	c.projectId = ptr(42)
+	c.accessToken = ptr("secret")
+	return c
+}
+
+func TestHTTPBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		section httpBackendSection
+		setup   func(c *config)
+		want    httpBackend
+	}{
+		{
+			name: "defaults",
+			want: httpBackend{
+				StateName:     "app-staging-tfstate",
+				Address:       "https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate",
+				LockAddress:   "https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate/lock",
+				UnlockAddress: "https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate/unlock",
+				LockMethod:    "POST",
+				UnlockMethod:  "DELETE",
+				Username:      "ubuntu",
+				Password:      "secret",
+				RetryMax:      defaultBackendRetryMax,
+				RetryWaitMin:  defaultBackendRetryWaitMin,
+				RetryWaitMax:  defaultBackendRetryWaitMax,
+			},
+		},
+		{
+			name:  "state name and default workspace",
+			setup: func(c *config) { c.tfStateName = ptr("shared"); c.workspace = ptr(defaultWorkspace) },
+			want: httpBackend{
+				StateName:     "shared",
+				Address:       "https://gitlab.com/api/v4/projects/42/terraform/state/shared",
+				LockAddress:   "https://gitlab.com/api/v4/projects/42/terraform/state/shared/lock",
+				UnlockAddress: "https://gitlab.com/api/v4/projects/42/terraform/state/shared/unlock",
+				LockMethod:    "POST",
+				UnlockMethod:  "DELETE",
+				Username:      "ubuntu",
+				Password:      "secret",
+				RetryMax:      defaultBackendRetryMax,
+				RetryWaitMin:  defaultBackendRetryWaitMin,
+				RetryWaitMax:  defaultBackendRetryWaitMax,
+			},
+		},
+		{
+			name: "section",
+			section: httpBackendSection{
+				Address:              "https://state.example.com/{{.ProjectID}}/{{.StateName}}",
+				LockAddress:          "{{.Address}}?lock",
+				LockMethod:           "LOCK",
+				UnlockMethod:         "UNLOCK",
+				Username:             "ci",
+				RetryMax:             ptr(0),
+				RetryWaitMin:         1,
+				RetryWaitMax:         2,
+				SkipCertVerification: true,
+			},
+			want: httpBackend{
+				StateName:            "app-staging-tfstate",
+				Address:              "https://state.example.com/42/app-staging-tfstate",
+				LockAddress:          "https://state.example.com/42/app-staging-tfstate?lock",
+				UnlockAddress:        "https://state.example.com/42/app-staging-tfstate/unlock",
+				LockMethod:           "LOCK",
+				UnlockMethod:         "UNLOCK",
+				Username:             "ci",
+				Password:             "secret",
+				RetryMax:             0,
+				RetryWaitMin:         1,
+				RetryWaitMax:         2,
+				SkipCertVerification: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := backendConfig(t)
+			c.sections.HTTPBackend = tt.section
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			got, backendErr := c.httpBackend()
+			if backendErr != nil {
+				t.Fatalf("httpBackend() error = %v", backendErr)
+			}
+			if got != tt.want {
+				t.Errorf("httpBackend() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPBackendInvalid(t *testing.T) {
+	for name, section := range map[string]httpBackendSection{
+		"unparsable address": {Address: "{{.API"},
+		"unknown field":      {Address: "{{.Project}}"},
+		"unknown lock field": {LockAddress: "{{.Lock}}"},
+		"unparsable unlock":  {UnlockAddress: "{{end}}"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := backendConfig(t)
+			c.sections.HTTPBackend = section
+			_, backendErr := c.httpBackend()
+			if backendErr == nil || !strings.Contains(backendErr.Error(), "invalid http_backend") {
+				t.Errorf("httpBackend() error = %v, want an invalid http_backend error", backendErr)
+			}
+		})
+	}
+}
+
+func TestHTTPBackendEnv(t *testing.T) {
+	c := backendConfig(t)
+	backend, backendErr := c.httpBackend()
+	if backendErr != nil {
+		t.Fatal(backendErr)
+	}
+	env := backend.env()
+	for _, want := range []string{
+		"TF_STATE_NAME=app-staging-tfstate",
+		"TF_HTTP_USERNAME=ubuntu",
+		"TF_HTTP_PASSWORD=secret",
+		"TF_HTTP_ADDRESS=https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate",
+		"TF_HTTP_LOCK_ADDRESS=https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate/lock",
+		"TF_HTTP_UNLOCK_ADDRESS=https://gitlab.com/api/v4/projects/42/terraform/state/app-staging-tfstate/unlock",
+		"TF_HTTP_LOCK_METHOD=POST",
+		"TF_HTTP_UNLOCK_METHOD=DELETE",
+		"TF_HTTP_RETRY_MAX=2",
+		"TF_HTTP_RETRY_WAIT_MIN=5",
+		"TF_HTTP_RETRY_WAIT_MAX=30",
+	} {
+		if !slices.Contains(env, want) {
+			t.Errorf("env() = %v, want %s", env, want)
+		}
+	}
+	if slices.ContainsFunc(env, func(v string) bool { return strings.HasPrefix(v, "TF_HTTP_SKIP_CERT_VERIFICATION=") }) {
+		t.Errorf("env() = %v, want no TF_HTTP_SKIP_CERT_VERIFICATION unless set", env)
+	}
+	backend.SkipCertVerification = true
+	if env := backend.env(); !slices.Contains(env, "TF_HTTP_SKIP_CERT_VERIFICATION=true") {
+		t.Errorf("env() = %v, want TF_HTTP_SKIP_CERT_VERIFICATION=true", env)
+	}
+	c.backend = backend
+	if env := c.getEnv(); !slices.Contains(env, "TF_WORKSPACE=staging") {
+		t.Errorf("getEnv() is missing TF_WORKSPACE=staging")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+const defaultWorkspace = "default"
+
+// cluster is one terraform directory, in one workspace, that hosts are discovered from. Its config
+// is a copy whose --tfdir and --workspace point at just this cluster, so the state name, http
+// backend and state cache are derived per cluster.
+type cluster struct {
+	name   string
+	config *config
+}
+
+// tfDirs expands the CSV of directories and globs in --tfdir, once each
+func (c *config) tfDirs() ([]string, error) {
+	var dirs []string
+	seen := make(map[string]bool)
+	add := func(dir string) {
+		if clean := filepath.Clean(dir); !seen[clean] {
+			seen[clean] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, pattern := range splitCSV(*c.tfDir) {
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+		matches, globErr := filepath.Glob(pattern)
+		if globErr != nil {
+			return nil, fmt.Errorf("invalid --tfdir glob %s: %w", pattern, globErr)
+		}
+		for _, match := range matches {
+			if info, statErr := os.Stat(match); statErr == nil && info.IsDir() {
+				add(match)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// clusterDirNames names every directory by its base name, or by its path when another directory
+// shares that base name, as envs/prod/app and envs/stage/app do
+func clusterDirNames(dirs []string) map[string]string {
+	bases := make(map[string]int, len(dirs))
+	for _, dir := range dirs {
+		bases[filepath.Base(filepath.Clean(dir))]++
+	}
+	names := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		names[dir] = filepath.Base(filepath.Clean(dir))
+		if bases[names[dir]] > 1 {
+			names[dir] = filepath.ToSlash(filepath.Clean(dir))
+		}
+	}
+	return names
+}
+
+// clusters pairs every --tfdir directory with every --workspace
+func (c *config) clusters() ([]cluster, error) {
+	dirs, dirsErr := c.tfDirs()
+	if dirsErr != nil {
+		return nil, dirsErr
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("--tfdir %s matches no directories", *c.tfDir)
+	}
+	workspaces := splitCSV(*c.workspace)
+	if len(workspaces) == 0 {
+		workspaces = []string{""}
+	}
+	if len(dirs)*len(workspaces) > 1 {
+		// both flags name one state, which every cluster would otherwise read
+		for name, value := range map[string]string{"tf-state": *c.tfState, "tf-state-name": *c.tfStateName} {
+			if len(value) > 0 {
+				return nil, fmt.Errorf("--%s names a single state but --tfdir and --workspace select %d clusters", name, len(dirs)*len(workspaces))
+			}
+		}
+	}
+	dirNames := clusterDirNames(dirs)
+	var clusters []cluster
+	for _, dir := range dirs {
+		for _, workspace := range workspaces {
+			cc := *c
+			cc.tfDir, cc.workspace = &dir, &workspace
+			cc.state = nil
+			backend, backendErr := cc.httpBackend()
+			if backendErr != nil {
+				return nil, backendErr
+			}
+			cc.backend = backend
+			name := dirNames[dir]
+			if len(workspace) > 0 {
+				name = fmt.Sprintf("%s:%s", name, workspace)
+			}
+			clusters = append(clusters, cluster{name: name, config: &cc})
+		}
+	}
+	return clusters, nil
+}
+
+// hasWorkspace is true when a workspace other than the default one is selected
+func (c *config) hasWorkspace() bool {
+	return len(*c.workspace) > 0 && *c.workspace != defaultWorkspace
+}
+
+// clusterHosts discovers the hosts of every cluster. With more than one cluster each host is
+// labelled <cluster>/<host>, since clusters may reuse the same private addresses.
+func (c *config) clusterHosts(clusters []cluster, jumps []transport.Host) ([]transport.Host, error) {
+	var hosts []transport.Host
+	for _, cl := range clusters {
+		found, discoveryErr := cl.config.terraformHosts(jumps)
+		if discoveryErr != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cl.name, discoveryErr)
+		}
+		if hostKeysErr := cl.config.terraformHostKeys(found); hostKeysErr != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cl.name, hostKeysErr)
+		}
+		if varsErr := cl.config.terraformVars(found); varsErr != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cl.name, varsErr)
+		}
+		for _, host := range found {
+			host.User = *c.user
+			if len(clusters) > 1 {
+				host.Cluster = cl.name
+				host.Label = fmt.Sprintf("%s/%s", cl.name, host)
+			}
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+func clusterNames(clusters []cluster) []string {
+	names := make([]string, 0, len(clusters))
+	for _, cl := range clusters {
+		names = append(names, cl.name)
+	}
+	return names
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// clusterDirs makes every directory under a temporary root, with a terraform.tfstate listing
+// one host at 10.0.0.1
+func clusterDirs(t *testing.T, dirs ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, dir := range dirs {
+		if mkdirErr := os.MkdirAll(filepath.Join(root, dir), 0700); mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+		state := `{"version": 4, "outputs": {"public_ips": {"value": ["10.0.0.1"]}}, "resources": []}`
+		if writeErr := os.WriteFile(filepath.Join(root, dir, defaultStateFile), []byte(state), 0600); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	// a file that globs match but that is not a cluster
+	if writeErr := os.WriteFile(filepath.Join(root, "clusters", "README.md"), nil, 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return root
+}
+
+// under joins every entry of a --tfdir CSV to root
+func under(root, csv string) string {
+	var dirs []string
+	for _, dir := range splitCSV(csv) {
+		dirs = append(dirs, filepath.Join(root, dir))
+	}
+	return strings.Join(dirs, ",")
+}
+
+func TestClusters(t *testing.T) {
+	root := clusterDirs(t, "clusters/a", "clusters/b", "envs/prod/app", "envs/stage/app", "legacy")
+	tests := []struct {
+		name      string
+		tfDir     string
+		workspace string
+		want      []string
+	}{
+		{name: "directory", tfDir: "legacy", want: []string{"legacy"}},
+		{name: "glob", tfDir: "clusters/*", want: []string{"a", "b"}},
+		{name: "glob and directory", tfDir: "clusters/*, legacy", want: []string{"a", "b", "legacy"}},
+		{name: "repeated directory", tfDir: "clusters/*,clusters/a,clusters/./b", want: []string{"a", "b"}},
+		{name: "workspaces", tfDir: "legacy", workspace: "prod,stage", want: []string{"legacy:prod", "legacy:stage"}},
+		{name: "duplicate basenames", tfDir: "envs/*/app,legacy", want: []string{"envs/prod/app", "envs/stage/app", "legacy"}},
+		{name: "duplicate basenames and workspaces", tfDir: "envs/*/app", workspace: "blue,green", want: []string{
+			"envs/prod/app:blue", "envs/prod/app:green", "envs/stage/app:blue", "envs/stage/app:green",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			*c.tfDir, *c.workspace = under(root, tt.tfDir), tt.workspace
+			clusters, clustersErr := c.clusters()
+			if clustersErr != nil {
+				t.Fatalf("clusters() error = %v", clustersErr)
+			}
+			var want []string
+			for _, name := range tt.want {
+				// clusters that share a basename are named by their whole path
+				if strings.Contains(name, "/") {
+					name = filepath.ToSlash(root) + "/" + name
+				}
+				want = append(want, name)
+			}
+			if got := clusterNames(clusters); !slices.Equal(got, want) {
+				t.Errorf("clusters() = %v, want %v", got, want)
+			}
+			for _, cl := range clusters {
+				if want := filepath.Base(*cl.config.tfDir) + "-"; !strings.HasPrefix(cl.config.backend.StateName, want) {
+					t.Errorf("cluster %s state name = %s, want it derived from %s", cl.name, cl.config.backend.StateName, *cl.config.tfDir)
+				}
+			}
+		})
+	}
+}
+
+func TestClusterDirNames(t *testing.T) {
+	got := clusterDirNames([]string{"envs/prod/app", "envs/stage/app/", "./envs/prod/db", "legacy"})
+	want := map[string]string{
+		"envs/prod/app":   "envs/prod/app",
+		"envs/stage/app/": "envs/stage/app",
+		"./envs/prod/db":  "db",
+		"legacy":          "legacy",
+	}
+	for dir, name := range want {
+		if got[dir] != name {
+			t.Errorf("clusterDirNames()[%s] = %s, want %s", dir, got[dir], name)
+		}
+	}
+}
+
+func TestClustersErrors(t *testing.T) {
+	root := clusterDirs(t, "clusters/a", "clusters/b")
+	tests := []struct {
+		name   string
+		modify func(c *config)
+		want   string
+	}{
+		{name: "no directories", modify: func(c *config) { *c.tfDir = "missing/*" }, want: "matches no directories"},
+		{name: "bad glob", modify: func(c *config) { *c.tfDir = "clusters/[" }, want: "invalid --tfdir glob"},
+		{name: "state file of two directories", modify: func(c *config) {
+			*c.tfDir, *c.tfState = "clusters/*", "terraform.tfstate"
+		}, want: "--tf-state names a single state"},
+		{name: "state name of two workspaces", modify: func(c *config) {
+			*c.tfDir, *c.workspace, *c.tfStateName = "clusters/a", "prod,stage", "shared"
+		}, want: "--tf-state-name names a single state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(c)
+			*c.tfDir = under(root, *c.tfDir)
+			if _, clustersErr := c.clusters(); clustersErr == nil || !strings.Contains(clustersErr.Error(), tt.want) {
+				t.Errorf("clusters() error = %v, want %q", clustersErr, tt.want)
+			}
+		})
+	}
+	t.Run("state name of one cluster", func(t *testing.T) {
+		c := testConfig()
+		*c.tfDir, *c.workspace, *c.tfStateName = under(root, "clusters/a"), "prod", "shared"
+		clusters, clustersErr := c.clusters()
+		if clustersErr != nil {
+			t.Fatalf("clusters() error = %v", clustersErr)
+		}
+		if got := clusters[0].config.backend.StateName; got != "shared" {
+			t.Errorf("clusters() state name = %s, want shared", got)
+		}
+	})
+}
+
+func TestClusterHosts(t *testing.T) {
+	root := clusterDirs(t, "clusters/a", "clusters/b")
+	tests := []struct {
+		name  string
+		tfDir string
+		want  []string
+	}{
+		{name: "one cluster", tfDir: "clusters/a", want: []string{"10.0.0.1"}},
+		{name: "two clusters", tfDir: "clusters/*", want: []string{"a/10.0.0.1", "b/10.0.0.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			*c.tfDir, *c.tfSource = under(root, tt.tfDir), tfSourceFile
+			clusters, clustersErr := c.clusters()
+			if clustersErr != nil {
+				t.Fatal(clustersErr)
+			}
+			hosts, hostsErr := c.clusterHosts(clusters, nil)
+			if hostsErr != nil {
+				t.Fatalf("clusterHosts() error = %v", hostsErr)
+			}
+			var got []string
+			for i, host := range hosts {
+				got = append(got, host.String())
+				if host.User != *c.user || host.Address != "10.0.0.1" {
+					t.Errorf("clusterHosts()[%d] = %s@%s, want %s@10.0.0.1", i, host.User, host.Address, *c.user)
+				}
+				if len(clusters) > 1 && host.Cluster != clusters[i].name {
+					t.Errorf("clusterHosts()[%d].Cluster = %q, want %q", i, host.Cluster, clusters[i].name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("clusterHosts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
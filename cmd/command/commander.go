@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -16,6 +17,8 @@ import (
 	"github.com/andreimerlescu/extra-ssh-bash/cmd/data"
 )
 
+const killWaitDelay = time.Second
+
 type CtxKeyPipeRawCommand string
 
 type UnsafeRawCommand string
@@ -24,6 +27,10 @@ type Commander struct {
 	Commands []Command
 	Stdout   []byte
 	Stderr   []byte
+
+	// StdoutStream and StderrStream receive output while the command runs when set
+	StdoutStream io.Writer
+	StderrStream io.Writer
 }
 
 type Command struct {
@@ -36,11 +43,12 @@ type Command struct {
 }
 
 type CommandOutput struct {
-	Command string
-	Runtime time.Duration
-	Stdout  []byte
-	Stderr  []byte
-	Error   error
+	Command  string
+	Runtime  time.Duration
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+	Error    error
 }
 
 func (cmd *Commander) String() string {
@@ -48,16 +56,28 @@ func (cmd *Commander) String() string {
 }
 
 func (cmd *Commander) Compile(command string) *exec.Cmd {
+	return cmd.CompileContext(context.Background(), command)
+}
+
+// CompileContext is Compile with a command that is killed once ctx is done
+func (cmd *Commander) CompileContext(ctx context.Context, command string) *exec.Cmd {
+	var c *exec.Cmd
 	if runtime.GOOS == "windows" {
-		c := exec.Command("cmd.exe", "/c "+command)
-		return c
+		c = exec.CommandContext(ctx, "cmd.exe", "/c "+command)
 	} else {
-		fields := strings.Fields(command)
-		if len(fields) < 2 {
-			return exec.Command(command)
+		fields, err := SplitFields(command)
+		if err != nil {
+			c = exec.CommandContext(ctx, command)
+			c.Err = err
+		} else if len(fields) == 0 {
+			c = exec.CommandContext(ctx, command)
+		} else {
+			c = exec.CommandContext(ctx, fields[0], fields[1:]...)
 		}
-		return exec.Command(fields[0], fields[1:]...)
 	}
+	// children that inherited stdout/stderr must not hold Wait open after the kill
+	c.WaitDelay = killWaitDelay
+	return c
 }
 
 func (cmd *Commander) Run(ctx context.Context, rawCommand UnsafeRawCommand, env []string, handler func(CommandOutput) bool) (CommandOutput, bool) {
@@ -84,7 +104,7 @@ func (cmd *Commander) runInsideWithInput(ctx context.Context, rawCommand UnsafeR
 		errBuff = bytes.Buffer{}
 	)
 
-	c := cmd.Compile(rawCmd)
+	c := cmd.CompileContext(ctx, rawCmd)
 	if len(env) > 0 {
 		c.Env = env
 	}
@@ -104,6 +124,12 @@ func (cmd *Commander) runInsideWithInput(ctx context.Context, rawCommand UnsafeR
 	}
 	c.Stdout = &outBuff
 	c.Stderr = &errBuff
+	if cmd.StdoutStream != nil {
+		c.Stdout = io.MultiWriter(&outBuff, cmd.StdoutStream)
+	}
+	if cmd.StderrStream != nil {
+		c.Stderr = io.MultiWriter(&errBuff, cmd.StderrStream)
+	}
 
 	_logs = append(_logs, fmt.Sprintf("PATH: %v", c.Path))
 	_logs = append(_logs, fmt.Sprintf("exec command: %v", rawCmd))
@@ -113,12 +139,15 @@ func (cmd *Commander) runInsideWithInput(ctx context.Context, rawCommand UnsafeR
 	if startErr != nil {
 		_logs = append(_logs, fmt.Sprint(fmt.Errorf("failed to start command with err: %v", startErr)))
 		PrintLogs(_logs)
-		return CommandOutput{Command: rawCmd, Stdout: outBuff.Bytes(), Stderr: errBuff.Bytes(), Error: startErr}, false
+		return CommandOutput{Command: rawCmd, Stdout: outBuff.Bytes(), Stderr: errBuff.Bytes(), ExitCode: -1, Error: startErr}, false
 	}
 
 	waitErr := c.Wait()
+	if waitErr != nil && ctx.Err() != nil {
+		waitErr = fmt.Errorf("%w: %v", ctx.Err(), waitErr)
+	}
 	if waitErr != nil {
-		return CommandOutput{Command: rawCmd, Stdout: outBuff.Bytes(), Stderr: errBuff.Bytes(), Error: waitErr}, false
+		return CommandOutput{Command: rawCmd, Stdout: outBuff.Bytes(), Stderr: errBuff.Bytes(), ExitCode: ExitCode(waitErr), Error: waitErr}, false
 	}
 
 	output.Stderr = errBuff.Bytes()
@@ -169,7 +198,7 @@ func (cmd *Commander) pipeWithInput(ctx context.Context, rawCmds []UnsafeRawComm
 
 		command := commands[i]
 
-		command.Cmd = cmd.Compile(command.Command)
+		command.Cmd = cmd.CompileContext(ctx, command.Command)
 		command.Cmd.Path = os.Getenv("PATH")
 		command.Cmd.Env = env
 		var stdoutBuf bytes.Buffer
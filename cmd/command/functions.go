@@ -2,8 +2,10 @@ package command
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"os"
+	"os/exec"
 )
 
 func BB(s string) (b []byte) {
@@ -38,3 +40,15 @@ func CreateDirectoryWithFileInfo(dir string) os.FileInfo {
 	}
 	return dirInfo
 }
+
+// ExitCode returns the exit status carried by err, 0 when err is nil and -1 when the process never exited
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -7,6 +7,7 @@ import (
 )
 
 type LineWriter struct {
+	raw    bool
 	lines  chan string
 	buffer []byte
 	done   chan bool
@@ -23,13 +24,21 @@ func NewLineWriter(size int) *LineWriter {
 	}
 }
 
+// NewRawLineWriter passes every line through, for output such as that of a remote host where a
+// line about a closed connection is only text
+func NewRawLineWriter(size int) *LineWriter {
+	lw := NewLineWriter(size)
+	lw.raw = true
+	return lw
+}
+
 func (lw *LineWriter) Write(p []byte) (n int, err error) {
 	lw.buffer = append(lw.buffer, p...)
 	start := 0
 	for i := 0; i < len(lw.buffer); i++ {
 		if lw.buffer[i] == '\n' {
 			line := string(lw.buffer[start:i])
-			if strings.Contains(line, "was forcibly closed by the remote host") {
+			if !lw.raw && strings.Contains(line, "was forcibly closed by the remote host") {
 				lw.errCh <- fmt.Errorf("error encountered: %s", line)
 			} else {
 				lw.lines <- line
@@ -41,11 +50,15 @@ func (lw *LineWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// Close emits any trailing line that did not end in a newline and closes Lines()
 func (lw *LineWriter) Close() {
 	lw.wg.Wait()
+	if len(lw.buffer) > 0 {
+		lw.lines <- string(lw.buffer)
+		lw.buffer = nil
+	}
 	close(lw.lines)
 	close(lw.errCh)
-	lw.done <- true
 	close(lw.done)
 }
 
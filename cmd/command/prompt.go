@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"strings"
 	"sync"
@@ -83,9 +84,9 @@ func (p *PromptHistory) Run(ctx context.Context, rawCmd string, sem sema.Semapho
 
 	commander, releaseSemaphore := Commander{}, false
 
-	if strings.Contains(rawCmd, " | ") {
+	if HasPipe(rawCmd) {
 
-		pipes := strings.Split(rawCmd, " | ")
+		pipes := SplitPipeline(rawCmd)
 		var commands []UnsafeRawCommand
 		for i := 0; i < len(pipes); i++ {
 			pipe := pipes[i]
@@ -137,7 +138,7 @@ func (p *PromptHistory) RunInside(ctx context.Context, rawCmd string, sem sema.S
 	innerCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	if strings.Contains(rawCmd, " | ") {
+	if HasPipe(rawCmd) {
 		return CommandOutput{Command: rawCmd, Error: errors.New("RunInside does not support commands with a pipe")}, false
 	}
 
@@ -169,10 +170,15 @@ func (p *PromptHistory) RunInside(ctx context.Context, rawCmd string, sem sema.S
 }
 
 func (p *PromptHistory) RunInsideWithInput(ctx context.Context, rawCmd string, sem sema.Semaphore, directory string, input string, env []string, handler func(CommandOutput) bool) (CommandOutput, bool) {
+	return p.RunInsideWithInputStream(ctx, rawCmd, sem, directory, input, env, nil, nil, handler)
+}
+
+// RunInsideWithInputStream is RunInsideWithInput that also copies output to stdout and stderr as it arrives
+func (p *PromptHistory) RunInsideWithInputStream(ctx context.Context, rawCmd string, sem sema.Semaphore, directory string, input string, env []string, stdout, stderr io.Writer, handler func(CommandOutput) bool) (CommandOutput, bool) {
 	innerCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	if strings.Contains(rawCmd, " | ") {
+	if HasPipe(rawCmd) {
 		return CommandOutput{Command: rawCmd, Error: errors.New("RunInside does not support commands with a pipe")}, false
 	}
 
@@ -181,7 +187,7 @@ func (p *PromptHistory) RunInsideWithInput(ctx context.Context, rawCmd string, s
 		log.Println(err)
 	}
 
-	commander, releaseSemaphore := Commander{}, false
+	commander, releaseSemaphore := Commander{StdoutStream: stdout, StderrStream: stderr}, false
 
 	if strings.Contains(rawCmd, "p4 ") {
 		sem.Acquire()
@@ -206,8 +212,8 @@ func (p *PromptHistory) RunInsideWithInput(ctx context.Context, rawCmd string, s
 
 func (p *PromptHistory) RunWithInput(ctx context.Context, rawCmd string, rawInput string, env []string, handler func(CommandOutput) bool) (CommandOutput, bool) {
 	cmdr := Commander{}
-	if strings.Contains(rawCmd, " | ") {
-		pipes := strings.Split(rawCmd, " | ")
+	if HasPipe(rawCmd) {
+		pipes := SplitPipeline(rawCmd)
 		var commands []UnsafeRawCommand
 		for i := 0; i < len(pipes); i++ {
 			commands = append(commands, UnsafeRawCommand(pipes[i]))
@@ -0,0 +1,110 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote wraps s in single quotes so a POSIX shell receives it as exactly one argument
+func Quote(s string) string {
+	if len(s) == 0 {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// SplitFields splits a command line into arguments the way a POSIX shell would, honoring
+// single quotes, double quotes and backslash escapes
+func SplitFields(command string) ([]string, error) {
+	var (
+		fields  []string
+		current strings.Builder
+		inField bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range command {
+		switch {
+		case escaped:
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inField = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inField = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inField {
+				fields = append(fields, current.String())
+				current.Reset()
+				inField = false
+			}
+		default:
+			current.WriteRune(r)
+			inField = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("command ends with an unfinished escape: %s", command)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("command has an unterminated %c quote: %s", quote, command)
+	}
+	if inField {
+		fields = append(fields, current.String())
+	}
+	return fields, nil
+}
+
+// SplitPipeline splits command on every | that is not quoted, escaped or part of ||
+func SplitPipeline(command string) []string {
+	var (
+		segments []string
+		start    int
+		quote    rune
+		escaped  bool
+		runes    = []rune(command)
+	)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '|':
+			if i+1 < len(runes) && runes[i+1] == '|' {
+				i++
+				continue
+			}
+			segments = append(segments, strings.TrimSpace(string(runes[start:i])))
+			start = i + 1
+		}
+	}
+	return append(segments, strings.TrimSpace(string(runes[start:])))
+}
+
+// HasPipe reports whether command contains a | that a shell would treat as a pipeline
+func HasPipe(command string) bool {
+	return len(SplitPipeline(command)) > 1
+}
@@ -0,0 +1,73 @@
+package command
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{command: "", want: nil},
+		{command: "  docker   ps  ", want: []string{"docker", "ps"}},
+		{command: `--env prod 'two words'`, want: []string{"--env", "prod", "two words"}},
+		{command: `"a \"b\" \$c \d"`, want: []string{`a "b" $c \d`}},
+		{command: `it\'s a\ b`, want: []string{"it's", "a b"}},
+		{command: `'' ""`, want: []string{"", ""}},
+		{command: `pre'quoted'post`, want: []string{"prequotedpost"}},
+	}
+	for _, tt := range tests {
+		got, err := SplitFields(tt.command)
+		if err != nil {
+			t.Errorf("SplitFields(%q) error = %v", tt.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitFields(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFieldsErrors(t *testing.T) {
+	for _, command := range []string{`'open`, `"open`, `trailing\`} {
+		if _, err := SplitFields(command); err == nil {
+			t.Errorf("SplitFields(%q) error = nil, want an error", command)
+		}
+	}
+}
+
+func TestQuoteRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "plain", "it's", `a "b" $c`, "docker ps | grep web && echo 'ok done'"} {
+		got, err := SplitFields(Quote(s))
+		if err != nil {
+			t.Errorf("SplitFields(Quote(%q)) error = %v", s, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != s {
+			t.Errorf("SplitFields(Quote(%q)) = %q, want one field", s, got)
+		}
+	}
+}
+
+func TestSplitPipeline(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{command: "docker ps", want: []string{"docker ps"}},
+		{command: "docker ps | grep web", want: []string{"docker ps", "grep web"}},
+		{command: "true || false", want: []string{"true || false"}},
+		{command: `echo 'a|b' "c|d" e\|f`, want: []string{`echo 'a|b' "c|d" e\|f`}},
+		{command: "a|b|c", want: []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		if got := SplitPipeline(tt.command); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitPipeline(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+	if HasPipe("true || false") {
+		t.Error("HasPipe(\"true || false\") = true, want false")
+	}
+}
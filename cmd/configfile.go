@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile sets the flags named by the top level keys of configFile. configurable assigns
+// YAML values to flags by reflection, which panics on a whole number for a float flag or a string
+// for a duration, so every value goes through the parsing of its flag instead, as if it had been
+// given on the command line.
+func loadConfigFile(flags *flag.FlagSet, configFile string) error {
+	bytes, readErr := os.ReadFile(configFile)
+	if readErr != nil {
+		return readErr
+	}
+	var values map[string]any
+	if yamlErr := yaml.Unmarshal(bytes, &values); yamlErr != nil {
+		return fmt.Errorf("invalid %s: %w", configFile, yamlErr)
+	}
+	for key, value := range values {
+		if flags.Lookup(key) == nil || value == nil {
+			// nested sections such as jump_hosts are read by loadSections
+			continue
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			return fmt.Errorf("invalid %s in %s: must be a single value", key, configFile)
+		}
+		if setErr := flags.Set(key, fmt.Sprint(value)); setErr != nil {
+			return fmt.Errorf("invalid %s %v in %s: %w", key, value, configFile, setErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFlags registers a flag of every type config.yaml can set
+func testFlags() *flag.FlagSet {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("json", false, "")
+	flags.Int("parallel", defaultParallel, "")
+	flags.String("fail-on", failOnAny, "")
+	flags.Float64("fail-percent", 0, "")
+	flags.Float64("batch-percent", 0, "")
+	flags.Float64("halt-percent", 100, "")
+	flags.Duration("timeout", 0, "")
+	flags.Duration("deadline", 0, "")
+	return flags
+}
+
+func writeConfig(t *testing.T, text string) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	if writeErr := os.WriteFile(configFile, []byte(text), 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	return configFile
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		flag  string
+		value string
+	}{
+		{name: "bool", yaml: "json: true", flag: "json", value: "true"},
+		{name: "int", yaml: "parallel: 4", flag: "parallel", value: "4"},
+		{name: "string", yaml: "fail-on: percent", flag: "fail-on", value: "percent"},
+		{name: "whole float", yaml: "fail-percent: 20", flag: "fail-percent", value: "20"},
+		{name: "float", yaml: "fail-percent: 12.5", flag: "fail-percent", value: "12.5"},
+		{name: "whole batch percent", yaml: "batch-percent: 10", flag: "batch-percent", value: "10"},
+		{name: "whole halt percent", yaml: "halt-percent: 20", flag: "halt-percent", value: "20"},
+		{name: "duration", yaml: "timeout: 30s", flag: "timeout", value: "30s"},
+		{name: "long duration", yaml: "deadline: 1h30m", flag: "deadline", value: (90 * time.Minute).String()},
+		{name: "nested section", yaml: "jump_hosts:\n  - address: bastion\nparallel: 2", flag: "parallel", value: "2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := testFlags()
+			if loadErr := loadConfigFile(flags, writeConfig(t, tt.yaml)); loadErr != nil {
+				t.Fatalf("loadConfigFile() error = %v", loadErr)
+			}
+			if got := flags.Lookup(tt.flag).Value.String(); got != tt.value {
+				t.Errorf("%s = %s, want %s", tt.flag, got, tt.value)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := map[string]string{
+		"not a number": "fail-percent: lots",
+		"no unit":      "timeout: 30",
+		"list":         "parallel: [1, 2]",
+		"invalid yaml": "parallel: : :",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			if loadErr := loadConfigFile(testFlags(), writeConfig(t, text)); loadErr == nil {
+				t.Errorf("loadConfigFile(%q) error = nil, want an error", text)
+			}
+		})
+	}
+}
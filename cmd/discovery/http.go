@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const fetchTimeout = 30 * time.Second
+
+// HTTPBackend is a terraform http backend, such as the GitLab managed state API, that serves the
+// state JSON at Address. Failed requests are retried RetryMax times, waiting RetryWaitMin at first
+// and doubling up to RetryWaitMax, the same way terraform does.
+type HTTPBackend struct {
+	Address              string
+	Username             string
+	Password             string
+	SkipCertVerification bool
+	RetryMax             int
+	RetryWaitMin         time.Duration
+	RetryWaitMax         time.Duration
+}
+
+// retryableError marks a failure worth retrying, like a 5xx response or a dropped connection
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+// FetchState downloads the state, authenticating the same way terraform does with basic auth
+func (b HTTPBackend) FetchState(ctx context.Context) ([]byte, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	if b.SkipCertVerification {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	wait := b.RetryWaitMin
+	for attempt := 0; ; attempt++ {
+		body, fetchErr := b.fetch(ctx, client)
+		var retryable retryableError
+		if fetchErr == nil || !errors.As(fetchErr, &retryable) || attempt >= b.RetryMax {
+			return body, fetchErr
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, max(b.RetryWaitMax, b.RetryWaitMin))
+	}
+}
+
+func (b HTTPBackend) fetch(ctx context.Context, client *http.Client) ([]byte, error) {
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, b.Address, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+	if len(b.Password) > 0 {
+		req.SetBasicAuth(b.Username, b.Password)
+	}
+	res, resErr := client.Do(req)
+	if resErr != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, retryableError{fmt.Errorf("failed to fetch terraform state from %s: %w", b.Address, resErr)}
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	body, readErr := io.ReadAll(res.Body)
+	if readErr != nil {
+		return nil, retryableError{fmt.Errorf("failed to read terraform state from %s: %w", b.Address, readErr)}
+	}
+	switch {
+	case res.StatusCode == http.StatusOK:
+		return body, nil
+	case res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("no terraform state at %s", b.Address)
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+		return nil, retryableError{fmt.Errorf("failed to fetch terraform state from %s: %s", b.Address, res.Status)}
+	default:
+		return nil, fmt.Errorf("failed to fetch terraform state from %s: %s", b.Address, res.Status)
+	}
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stateServer answers with the statuses in order, repeating the last one, and counts the requests
+func stateServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		status := statuses[min(n, len(statuses))-1]
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			_, _ = w.Write([]byte(`{"version": 4}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func backend(address string) HTTPBackend {
+	return HTTPBackend{Address: address, RetryMax: 2, RetryWaitMin: time.Millisecond, RetryWaitMax: 2 * time.Millisecond}
+}
+
+func TestFetchState(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  string
+		requests int32
+	}{
+		{name: "ok", statuses: []int{http.StatusOK}, requests: 1},
+		{name: "not found", statuses: []int{http.StatusNotFound}, wantErr: "no terraform state", requests: 1},
+		{name: "no content", statuses: []int{http.StatusNoContent}, wantErr: "no terraform state", requests: 1},
+		{name: "forbidden", statuses: []int{http.StatusForbidden}, wantErr: "403", requests: 1},
+		{name: "retried", statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK}, requests: 3},
+		{name: "retries exhausted", statuses: []int{http.StatusServiceUnavailable}, wantErr: "503", requests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := stateServer(t, tt.statuses...)
+			body, fetchErr := backend(server.URL).FetchState(context.Background())
+			switch {
+			case len(tt.wantErr) == 0 && fetchErr != nil:
+				t.Fatalf("FetchState() error = %v", fetchErr)
+			case len(tt.wantErr) == 0 && string(body) != `{"version": 4}`:
+				t.Errorf("FetchState() = %s, want the state", body)
+			case len(tt.wantErr) > 0 && (fetchErr == nil || !strings.Contains(fetchErr.Error(), tt.wantErr)):
+				t.Errorf("FetchState() error = %v, want %s", fetchErr, tt.wantErr)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("FetchState() made %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestFetchStateBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "gitlab-ci-token" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	b := backend(server.URL)
+	if _, fetchErr := b.FetchState(context.Background()); fetchErr == nil {
+		t.Errorf("FetchState() without credentials error = nil, want 401")
+	}
+	b.Username, b.Password = "gitlab-ci-token", "secret"
+	if _, fetchErr := b.FetchState(context.Background()); fetchErr != nil {
+		t.Errorf("FetchState() error = %v", fetchErr)
+	}
+}
+
+func TestFetchStateCanceled(t *testing.T) {
+	server, _ := stateServer(t, http.StatusInternalServerError)
+	b := backend(server.URL)
+	b.RetryMax, b.RetryWaitMin = 10, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, fetchErr := b.FetchState(ctx); fetchErr != context.DeadlineExceeded {
+		t.Errorf("FetchState() error = %v, want %v", fetchErr, context.DeadlineExceeded)
+	}
+}
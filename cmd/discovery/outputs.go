@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// PublicAddressKeys and PrivateAddressKeys are the attributes, in order of preference, that hold
+// the address of an object in a list-of-objects or map-of-objects output
+var (
+	PublicAddressKeys  = []string{"public_ip", "public_dns", "private_ip", "private_dns", "ip", "address"}
+	PrivateAddressKeys = []string{"private_ip", "private_dns", "ip", "address", "public_ip", "public_dns"}
+)
+
+// labelKeys are the attributes, in order of preference, that name an object
+var labelKeys = []string{"name", "instance_id", "id"}
+
+// ParseOutput reads the hosts out of the JSON value of a terraform output, which may be a single
+// address, a list of addresses, a map of label to address, or a list or map of objects. Map keys
+// label their hosts, and objects are labelled by their tags.Name, name, instance_id or id.
+func ParseOutput(name string, raw []byte, addressKeys []string) ([]transport.Host, error) {
+	var value any
+	if jsonErr := json.Unmarshal(raw, &value); jsonErr != nil {
+		return nil, fmt.Errorf("terraform output %s is not JSON: %w", name, jsonErr)
+	}
+	hosts, parseErr := fromValue(value, "", addressKeys)
+	if parseErr != nil {
+		return nil, fmt.Errorf("terraform output %s: %w", name, parseErr)
+	}
+	return hosts, nil
+}
+
+func fromValue(value any, label string, addressKeys []string) ([]transport.Host, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if len(v) == 0 {
+			return nil, nil
+		}
+		return []transport.Host{{Label: label, Address: v}}, nil
+	case []any:
+		var hosts []transport.Host
+		for i, item := range v {
+			itemLabel := label
+			if len(label) > 0 && len(v) > 1 {
+				itemLabel = fmt.Sprintf("%s-%d", label, i)
+			}
+			itemHosts, err := fromValue(item, itemLabel, addressKeys)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, itemHosts...)
+		}
+		return hosts, nil
+	case map[string]any:
+		if isObject(v, addressKeys) {
+			return fromObject(v, label, addressKeys), nil
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var hosts []transport.Host
+		for _, key := range keys {
+			keyHosts, err := fromValue(v[key], key, addressKeys)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, keyHosts...)
+		}
+		return hosts, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v of type %T, expected addresses, a map of label to address or objects", v, v)
+	}
+}
+
+// isObject tells an instance-like object, which may have no address yet, from a map of label to address
+func isObject(value map[string]any, addressKeys []string) bool {
+	for _, key := range append(append([]string{"tags"}, labelKeys...), addressKeys...) {
+		if _, ok := value[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fromObject returns the host of object, or no host when it has no address
+func fromObject(object map[string]any, label string, addressKeys []string) []transport.Host {
+	address, ok := lookup(object, addressKeys)
+	if !ok {
+		return nil
+	}
+	if objectLabel := labelOf(object); len(objectLabel) > 0 {
+		label = objectLabel
+	}
+	return []transport.Host{{Label: label, Address: address, Vars: vars(object)}}
+}
+
+// vars are the text attributes of object, with its tags as tags.<key>
+func vars(object map[string]any) map[string]string {
+	out := make(map[string]string)
+	for key, value := range object {
+		switch v := value.(type) {
+		case string:
+			out[key] = v
+		case float64, bool:
+			out[key] = fmt.Sprint(v)
+		}
+	}
+	if tags, ok := object["tags"].(map[string]any); ok {
+		for key, value := range tags {
+			if text, ok := value.(string); ok {
+				out["tags."+key] = text
+			}
+		}
+	}
+	return out
+}
+
+func lookup(object map[string]any, keys []string) (string, bool) {
+	for _, key := range keys {
+		if value, ok := object[key].(string); ok && len(value) > 0 {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func labelOf(object map[string]any) string {
+	if tags, ok := object["tags"].(map[string]any); ok {
+		if name, ok := tags["Name"].(string); ok && len(name) > 0 {
+			return name
+		}
+	}
+	label, _ := lookup(object, labelKeys)
+	return label
+}
+
+// Merge appends the hosts of every output in order, skipping addresses that were already found
+func Merge(outputs ...[]transport.Host) []transport.Host {
+	seen := make(map[string]bool)
+	var hosts []transport.Host
+	for _, output := range outputs {
+		for _, host := range output {
+			if seen[host.Address] {
+				continue
+			}
+			seen[host.Address] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// describe renders hosts as label=address pairs, with an empty label for unlabelled hosts
+func describe(hosts []transport.Host) string {
+	pairs := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", host.Label, host.Address))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func TestParseOutput(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "single address", raw: `"10.0.0.1"`, want: "=10.0.0.1"},
+		{name: "empty address", raw: `""`, want: ""},
+		{name: "null", raw: `null`, want: ""},
+		{name: "list of addresses", raw: `["10.0.0.1", "10.0.0.2"]`, want: "=10.0.0.1,=10.0.0.2"},
+		{name: "map of label to address", raw: `{"web": "10.0.0.2", "db": "10.0.0.1"}`, want: "db=10.0.0.1,web=10.0.0.2"},
+		{name: "map of label to addresses", raw: `{"web": ["10.0.0.1", "10.0.0.2"], "db": ["10.0.0.3"]}`, want: "db=10.0.0.3,web-0=10.0.0.1,web-1=10.0.0.2"},
+		{
+			name: "list of objects",
+			raw:  `[{"id": "i-1", "public_ip": "1.1.1.1", "private_ip": "10.0.0.1"}, {"tags": {"Name": "web"}, "private_ip": "10.0.0.2"}]`,
+			want: "i-1=1.1.1.1,web=10.0.0.2",
+		},
+		{
+			name: "map of objects",
+			raw:  `{"a": {"name": "db", "private_ip": "10.0.0.3"}, "b": {"private_ip": "10.0.0.4"}}`,
+			want: "db=10.0.0.3,b=10.0.0.4",
+		},
+		{name: "object without an address", raw: `[{"id": "i-1"}]`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, parseErr := ParseOutput("ips", []byte(tt.raw), PublicAddressKeys)
+			if parseErr != nil {
+				t.Fatalf("ParseOutput() error = %v", parseErr)
+			}
+			if got := describe(hosts); got != tt.want {
+				t.Errorf("ParseOutput(%s) = %s, want %s", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOutputVars(t *testing.T) {
+	raw := `[{"id": "i-1", "private_ip": "10.0.0.1", "count": 2, "tags": {"Name": "web", "env": "prod"}}]`
+	hosts, parseErr := ParseOutput("instances", []byte(raw), PrivateAddressKeys)
+	if parseErr != nil {
+		t.Fatalf("ParseOutput() error = %v", parseErr)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("ParseOutput() = %d hosts, want 1", len(hosts))
+	}
+	want := map[string]string{"id": "i-1", "private_ip": "10.0.0.1", "count": "2", "tags.Name": "web", "tags.env": "prod"}
+	for key, value := range want {
+		if got := hosts[0].Vars[key]; got != value {
+			t.Errorf("Vars[%s] = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestParseOutputErrors(t *testing.T) {
+	for _, raw := range []string{`not json`, `42`, `[true]`, `{"web": 1}`} {
+		if _, parseErr := ParseOutput("ips", []byte(raw), PublicAddressKeys); parseErr == nil {
+			t.Errorf("ParseOutput(%s) error = nil, want an error", raw)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := []transport.Host{{Label: "a", Address: "10.0.0.1"}, {Label: "b", Address: "10.0.0.2"}}
+	second := []transport.Host{{Label: "c", Address: "10.0.0.2"}, {Label: "d", Address: "10.0.0.3"}}
+	if got, want := describe(Merge(first, second)), "a=10.0.0.1,b=10.0.0.2,d=10.0.0.3"; got != want {
+		t.Errorf("Merge() = %s, want %s", got, want)
+	}
+}
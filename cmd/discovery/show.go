@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// showValues is the values block of terraform show -json, a tree of modules and their resources
+type showValues struct {
+	Outputs    map[string]StateOutput `json:"outputs"`
+	RootModule showModule             `json:"root_module"`
+}
+
+type showModule struct {
+	Address      string         `json:"address"`
+	Resources    []showResource `json:"resources"`
+	ChildModules []showModule   `json:"child_modules"`
+}
+
+type showResource struct {
+	Mode   string         `json:"mode"`
+	Type   string         `json:"type"`
+	Name   string         `json:"name"`
+	Index  any            `json:"index"`
+	Values map[string]any `json:"values"`
+}
+
+// parseShow converts terraform show -json into a State. A plan contributes the resources that
+// already exist in its prior state, falling back to its planned values.
+func parseShow(raw []byte) (*State, error) {
+	var show struct {
+		Values     *showValues `json:"values"`
+		PriorState *struct {
+			Values *showValues `json:"values"`
+		} `json:"prior_state"`
+		PlannedValues *showValues `json:"planned_values"`
+	}
+	if jsonErr := json.Unmarshal(raw, &show); jsonErr != nil {
+		return nil, fmt.Errorf("terraform show output is not JSON: %w", jsonErr)
+	}
+	values := show.Values
+	if values == nil && show.PriorState != nil {
+		values = show.PriorState.Values
+	}
+	if values == nil {
+		values = show.PlannedValues
+	}
+	state := &State{Version: 4, Outputs: make(map[string]StateOutput)}
+	if values == nil {
+		return state, nil
+	}
+	for name, output := range values.Outputs {
+		state.Outputs[name] = output
+	}
+	state.addModule(values.RootModule)
+	return state, nil
+}
+
+func (s *State) addModule(module showModule) {
+	for _, r := range module.Resources {
+		s.Resources = append(s.Resources, StateResource{
+			Module:    module.Address,
+			Mode:      r.Mode,
+			Type:      r.Type,
+			Name:      r.Name,
+			Instances: []StateInstance{{IndexKey: r.Index, Attributes: r.Values}},
+		})
+	}
+	for _, child := range module.ChildModules {
+		s.addModule(child)
+	}
+}
@@ -0,0 +1,152 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// InstanceType is the resource type whose instances are targeted when no output lists the hosts
+const InstanceType = "aws_instance"
+
+// State is the part of a terraform state file (format version 4) that holds hosts
+type State struct {
+	Version   int                    `json:"version"`
+	Outputs   map[string]StateOutput `json:"outputs"`
+	Resources []StateResource        `json:"resources"`
+}
+
+type StateOutput struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type StateResource struct {
+	Module    string          `json:"module,omitempty"`
+	Mode      string          `json:"mode"`
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	Instances []StateInstance `json:"instances"`
+}
+
+type StateInstance struct {
+	IndexKey   any            `json:"index_key,omitempty"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// Filter selects resource instances by resource address, like aws_instance.docker_member, and by
+// tags whose values are globs. An empty Filter selects nothing and leaves hosts to the outputs.
+type Filter struct {
+	Resources []string
+	Tags      map[string]string
+}
+
+// ParseState reads a terraform state file, or the output of terraform show -json for a state or a plan
+func ParseState(raw []byte) (*State, error) {
+	var probe struct {
+		FormatVersion string `json:"format_version"`
+	}
+	if jsonErr := json.Unmarshal(raw, &probe); jsonErr != nil {
+		return nil, fmt.Errorf("terraform state is not JSON: %w", jsonErr)
+	}
+	if len(probe.FormatVersion) > 0 {
+		return parseShow(raw)
+	}
+	var state State
+	if jsonErr := json.Unmarshal(raw, &state); jsonErr != nil {
+		return nil, fmt.Errorf("terraform state is not JSON: %w", jsonErr)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported terraform state version %d, expected 4", state.Version)
+	}
+	return &state, nil
+}
+
+func (f Filter) empty() bool {
+	return len(f.Resources) == 0 && len(f.Tags) == 0
+}
+
+func (f Filter) matches(r StateResource, instance StateInstance) bool {
+	if len(f.Resources) > 0 {
+		address := strings.TrimPrefix(fmt.Sprintf("%s.%s.%s", r.Module, r.Type, r.Name), ".")
+		matched := false
+		for _, resource := range f.Resources {
+			if resource == address || resource == r.label(instance) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	} else if r.Type != InstanceType {
+		return false
+	}
+	tags, _ := instance.Attributes["tags"].(map[string]any)
+	for key, pattern := range f.Tags {
+		value, ok := tags[key].(string)
+		if !ok {
+			return false
+		}
+		if matched, _ := path.Match(pattern, value); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Hosts reads the hosts of the resource instances that filter selects. Without a filter they
+// come from the named outputs, or from every aws_instance resource when the state has none of them.
+func (s *State) Hosts(outputs []string, filter Filter, addressKeys []string) ([]transport.Host, error) {
+	if !filter.empty() {
+		return s.instanceHosts(filter, addressKeys), nil
+	}
+	var found [][]transport.Host
+	for _, name := range outputs {
+		output, ok := s.Outputs[name]
+		if !ok {
+			continue
+		}
+		hosts, parseErr := ParseOutput(name, output.Value, addressKeys)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		found = append(found, hosts)
+	}
+	if len(found) > 0 {
+		return Merge(found...), nil
+	}
+	return s.instanceHosts(filter, addressKeys), nil
+}
+
+func (s *State) instanceHosts(filter Filter, addressKeys []string) []transport.Host {
+	var hosts []transport.Host
+	for _, resource := range s.Resources {
+		if resource.Mode != "managed" {
+			continue
+		}
+		for _, instance := range resource.Instances {
+			if filter.matches(resource, instance) {
+				hosts = append(hosts, fromObject(instance.Attributes, resource.label(instance), addressKeys)...)
+			}
+		}
+	}
+	return Merge(hosts)
+}
+
+// label names an instance by its resource address, such as aws_instance.docker_member[0]
+func (r StateResource) label(instance StateInstance) string {
+	label := fmt.Sprintf("%s.%s", r.Type, r.Name)
+	if len(r.Module) > 0 {
+		label = fmt.Sprintf("%s.%s", r.Module, label)
+	}
+	switch key := instance.IndexKey.(type) {
+	case float64:
+		label = fmt.Sprintf("%s[%d]", label, int(key))
+	case string:
+		label = fmt.Sprintf("%s[%q]", label, key)
+	}
+	return label
+}
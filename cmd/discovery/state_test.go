@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"strings"
+	"testing"
+)
+
+// stateV4 is a terraform.tfstate with an output, two counted instances, a tagged instance in a
+// module, an instance without an address and a data source
+const stateV4 = `{
+  "version": 4,
+  "outputs": {"public_ips": {"value": ["1.1.1.1", "1.1.1.2"]}},
+  "resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "docker_member", "instances": [
+      {"index_key": 0, "attributes": {"public_ip": "1.1.1.1", "private_ip": "10.0.0.1", "tags": {"Role": "docker", "Env": "prod-eu"}}},
+      {"index_key": 1, "attributes": {"public_ip": "1.1.1.2", "private_ip": "10.0.0.2", "tags": {"Role": "docker", "Env": "stage"}}}
+    ]},
+    {"module": "module.bastion", "mode": "managed", "type": "aws_instance", "name": "this", "instances": [
+      {"attributes": {"public_ip": "1.1.1.3", "tags": {"Name": "bastion", "Role": "bastion"}}}
+    ]},
+    {"mode": "managed", "type": "aws_instance", "name": "pending", "instances": [{"attributes": {"id": "i-9"}}]},
+    {"mode": "managed", "type": "aws_eip", "name": "ip", "instances": [{"attributes": {"public_ip": "1.1.1.9"}}]},
+    {"mode": "data", "type": "aws_instance", "name": "lookup", "instances": [{"attributes": {"public_ip": "1.1.1.8"}}]}
+  ]
+}`
+
+// showState is terraform show -json of a state, with resources in a nested module
+const showState = `{
+  "format_version": "1.0",
+  "values": {
+    "outputs": {"private_ips": {"value": {"web": "10.0.0.1"}}},
+    "root_module": {
+      "resources": [{"address": "aws_instance.web", "mode": "managed", "type": "aws_instance", "name": "web", "index": 0,
+        "values": {"public_ip": "1.1.1.1", "tags": {"Name": "web-0"}}}],
+      "child_modules": [{"address": "module.db", "resources": [{"mode": "managed", "type": "aws_instance", "name": "this",
+        "values": {"public_ip": "1.1.1.4", "tags": {"Name": "db"}}}]}]
+    }
+  }
+}`
+
+// plan builds terraform show -json of a plan, with the existing web host in its prior state and
+// a new host in its planned values
+func plan(prior string) string {
+	return `{
+  "format_version": "1.2",` + prior + `
+  "planned_values": {"root_module": {"resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "new", "values": {"public_ip": "1.1.1.5"}}
+  ]}}
+}`
+}
+
+const priorState = `
+  "prior_state": {"format_version": "1.0", "values": {"root_module": {"resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "web", "values": {"public_ip": "1.1.1.1"}}
+  ]}}},`
+
+func TestParseState(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		resources int
+		want      string
+	}{
+		{name: "v4 state", raw: stateV4, resources: 5, want: "aws_instance.docker_member[0]=1.1.1.1,aws_instance.docker_member[1]=1.1.1.2,bastion=1.1.1.3"},
+		{name: "show state", raw: showState, resources: 2, want: "web-0=1.1.1.1,db=1.1.1.4"},
+		{name: "plan with prior state", raw: plan(priorState), resources: 1, want: "aws_instance.web=1.1.1.1"},
+		{name: "plan of a new workspace", raw: plan(""), resources: 1, want: "aws_instance.new=1.1.1.5"},
+		{name: "plan with empty prior state", raw: plan(`"prior_state": {"format_version": "1.0"},`), resources: 1, want: "aws_instance.new=1.1.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, parseErr := ParseState([]byte(tt.raw))
+			if parseErr != nil {
+				t.Fatalf("ParseState() error = %v", parseErr)
+			}
+			if got := len(state.Resources); got != tt.resources {
+				t.Errorf("ParseState() has %d resources, want %d", got, tt.resources)
+			}
+			// no outputs are asked for, so every aws_instance is a host
+			hosts, hostsErr := state.Hosts(nil, Filter{}, PublicAddressKeys)
+			if hostsErr != nil {
+				t.Fatalf("Hosts() error = %v", hostsErr)
+			}
+			if got := describe(hosts); got != tt.want {
+				t.Errorf("Hosts() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStateErrors(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not json":   `terraform`,
+		"version 3":  `{"version": 3, "modules": []}`,
+		"bad show":   `{"format_version": "1.0", "values": []}`,
+		"no version": `{"resources": []}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, parseErr := ParseState([]byte(raw)); parseErr == nil {
+				t.Errorf("ParseState(%s) error = nil, want an error", raw)
+			}
+		})
+	}
+}
+
+func TestStateHostsOutputs(t *testing.T) {
+	state, parseErr := ParseState([]byte(showState))
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	hosts, hostsErr := state.Hosts([]string{"missing", "private_ips"}, Filter{}, PrivateAddressKeys)
+	if hostsErr != nil {
+		t.Fatalf("Hosts() error = %v", hostsErr)
+	}
+	if got, want := describe(hosts), "web=10.0.0.1"; got != want {
+		t.Errorf("Hosts() = %s, want the output %s over the instances", got, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	state, parseErr := ParseState([]byte(stateV4))
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+	}{
+		{name: "resource", filter: Filter{Resources: []string{"aws_instance.docker_member"}}, want: "1.1.1.1,1.1.1.2"},
+		{name: "indexed instance", filter: Filter{Resources: []string{"aws_instance.docker_member[1]"}}, want: "1.1.1.2"},
+		{name: "module resource", filter: Filter{Resources: []string{"module.bastion.aws_instance.this"}}, want: "1.1.1.3"},
+		{name: "other resource type", filter: Filter{Resources: []string{"aws_eip.ip"}}, want: "1.1.1.9"},
+		{name: "tag", filter: Filter{Tags: map[string]string{"Role": "docker"}}, want: "1.1.1.1,1.1.1.2"},
+		{name: "tag glob", filter: Filter{Tags: map[string]string{"Role": "docker", "Env": "prod*"}}, want: "1.1.1.1"},
+		{name: "tags only match instances", filter: Filter{Tags: map[string]string{"Role": "*"}}, want: "1.1.1.1,1.1.1.2,1.1.1.3"},
+		{name: "resource and tag", filter: Filter{Resources: []string{"module.bastion.aws_instance.this"}, Tags: map[string]string{"Role": "docker"}}, want: ""},
+		{name: "missing tag", filter: Filter{Tags: map[string]string{"Team": "*"}}, want: ""},
+		{name: "unknown resource", filter: Filter{Resources: []string{"aws_instance.nope"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, hostsErr := state.Hosts([]string{"public_ips"}, tt.filter, PublicAddressKeys)
+			if hostsErr != nil {
+				t.Fatalf("Hosts() error = %v", hostsErr)
+			}
+			addresses := make([]string, 0, len(hosts))
+			for _, host := range hosts {
+				addresses = append(addresses, host.Address)
+			}
+			if got := strings.Join(addresses, ","); got != tt.want {
+				t.Errorf("Hosts(%+v) = %s, want %s", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+)
+
+// Process exit codes, distinct so CI jobs can tell why a run failed
+const (
+	exitOK              = 0
+	exitConfigError     = 2
+	exitDiscoveryFailed = 3
+	exitHostsFailed     = 4
+)
+
+const (
+	failOnAny     = "any"
+	failOnPercent = "percent"
+	failOnAll     = "all"
+)
+
+func fatal(code int, v ...any) {
+	log.Println(v...)
+	os.Exit(code)
+}
+
+func (c *config) validateFailOn() error {
+	switch *c.failOn {
+	case failOnAny, failOnAll:
+		return nil
+	case failOnPercent:
+		if *c.failPercent < 0 || *c.failPercent > 100 {
+			return fmt.Errorf("--fail-percent must be between 0 and 100, got %v", *c.failPercent)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported --fail-on %s, valid options are: %s, %s, %s", *c.failOn, failOnAny, failOnPercent, failOnAll)
+	}
+}
+
+// exitCode applies the --fail-on semantics to the results of a run
+func (c *config) exitCode(results map[string]fleet.Result) int {
+	total, failed := len(results), 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	if total == 0 || failed == 0 {
+		return exitOK
+	}
+	switch *c.failOn {
+	case failOnAll:
+		if failed == total {
+			return exitHostsFailed
+		}
+	case failOnPercent:
+		if float64(failed)*100/float64(total) > *c.failPercent {
+			return exitHostsFailed
+		}
+	default:
+		return exitHostsFailed
+	}
+	return exitOK
+}
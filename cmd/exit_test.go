@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+)
+
+// statuses are the results of a run with one host per status
+func statuses(list ...string) map[string]fleet.Result {
+	results := make(map[string]fleet.Result, len(list))
+	for i, status := range list {
+		results[string(rune('a'+i))] = fleet.Result{Status: status}
+	}
+	return results
+}
+
+func TestExitCode(t *testing.T) {
+	ok, failed := fleet.StatusOK, fleet.StatusFailed
+	tests := []struct {
+		name        string
+		failOn      string
+		failPercent float64
+		results     map[string]fleet.Result
+		want        int
+	}{
+		{name: "no hosts", failOn: failOnAny, results: statuses(), want: exitOK},
+		{name: "all ok", failOn: failOnAny, results: statuses(ok, ok, ok), want: exitOK},
+		{name: "any failed", failOn: failOnAny, results: statuses(ok, ok, failed), want: exitHostsFailed},
+		{name: "any timed out", failOn: failOnAny, results: statuses(ok, fleet.StatusTimeout), want: exitHostsFailed},
+		{name: "any skipped", failOn: failOnAny, results: statuses(ok, fleet.StatusSkipped), want: exitHostsFailed},
+		{name: "all, some failed", failOn: failOnAll, results: statuses(ok, failed), want: exitOK},
+		{name: "all, every host failed", failOn: failOnAll, results: statuses(failed, fleet.StatusError), want: exitHostsFailed},
+		{name: "percent, within", failOn: failOnPercent, failPercent: 50, results: statuses(ok, failed), want: exitOK},
+		{name: "percent, over", failOn: failOnPercent, failPercent: 25, results: statuses(ok, ok, failed), want: exitHostsFailed},
+		{name: "percent, zero tolerated", failOn: failOnPercent, results: statuses(ok, ok, ok, failed), want: exitHostsFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.failOn, c.failPercent = &tt.failOn, &tt.failPercent
+			if got := c.exitCode(tt.results); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetsExitCode(t *testing.T) {
+	dir := t.TempDir()
+	emptyState := filepath.Join(dir, "empty.tfstate")
+	if writeErr := os.WriteFile(emptyState, []byte(`{"version": 4, "outputs": {}, "resources": []}`), 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	tests := []struct {
+		name   string
+		modify func(c *config)
+		want   int
+	}{
+		{name: "ipcsv", modify: func(c *config) { *c.ipCSV = "10.0.0.1, 10.0.0.2" }, want: exitOK},
+		{name: "no hosts", modify: func(c *config) { *c.tfDir = filepath.Join(dir, "missing") }, want: exitConfigError},
+		{name: "group without inventory", modify: func(c *config) { *c.group = "web" }, want: exitConfigError},
+		{name: "missing inventory", modify: func(c *config) { *c.inventory = filepath.Join(dir, "missing.yaml") }, want: exitConfigError},
+		{name: "unreadable state", modify: func(c *config) {
+			*c.tfSource, *c.tfDir, *c.tfState = tfSourceFile, dir, filepath.Join(dir, "missing.tfstate")
+		}, want: exitDiscoveryFailed},
+		{name: "state without hosts", modify: func(c *config) {
+			*c.tfSource, *c.tfDir, *c.tfState = tfSourceFile, dir, emptyState
+		}, want: exitDiscoveryFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			tt.modify(c)
+			_, _, code, targetsErr := c.targets(nil)
+			if code != tt.want {
+				t.Errorf("targets() code = %d, error = %v, want code %d", code, targetsErr, tt.want)
+			}
+			if (targetsErr == nil) != (tt.want == exitOK) {
+				t.Errorf("targets() error = %v, want an error only with a non-zero code", targetsErr)
+			}
+		})
+	}
+}
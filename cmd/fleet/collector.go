@@ -0,0 +1,64 @@
+package fleet
+
+import (
+	"sort"
+	"sync"
+)
+
+// Collector gathers Results from concurrent host sessions
+type Collector struct {
+	mu      sync.Mutex
+	order   []string
+	results map[string]Result
+}
+
+func NewCollector() *Collector {
+	return &Collector{results: make(map[string]Result)}
+}
+
+func (c *Collector) Add(host string, result Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.results[host]; !exists {
+		c.order = append(c.order, host)
+	}
+	c.results[host] = result
+}
+
+func (c *Collector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.results)
+}
+
+// Results returns a copy of the collected Results keyed by host
+func (c *Collector) Results() map[string]Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]Result, len(c.results))
+	for host, result := range c.results {
+		out[host] = result
+	}
+	return out
+}
+
+// Hosts returns the hosts in the order their Results were collected
+func (c *Collector) Hosts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.order...)
+}
+
+// GroupByCluster orders hosts by the position of their cluster in clusters, keeping the order of
+// hosts within each cluster
+func GroupByCluster(hosts []string, results map[string]Result, clusters []string) []string {
+	position := make(map[string]int, len(clusters))
+	for i, cluster := range clusters {
+		position[cluster] = i
+	}
+	grouped := append([]string{}, hosts...)
+	sort.SliceStable(grouped, func(i, j int) bool {
+		return position[results[grouped[i]].Cluster] < position[results[grouped[j]].Cluster]
+	})
+	return grouped
+}
@@ -0,0 +1,137 @@
+package fleet
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	sema "github.com/andreimerlescu/go-sema"
+)
+
+// Executor fans a Session out to every host over a single Transport. Limit bounds the number of
+// in-flight sessions and Rollout decides the waves hosts run in, each wave finishing before the
+// next one starts. Operation, when set, replaces running the Session as it is on each host, and
+// OnResult, when set, receives the Result of every host as soon as it is collected.
+type Executor struct {
+	Transport transport.Transport
+	Session   transport.Session
+	Limit     sema.Semaphore
+	Rollout   Rollout
+	Timeout   time.Duration
+	Stream    *Stream
+	Operation Operation
+	OnResult  func(host string, result Result)
+}
+
+// Operation is the work done on a single host, such as copying files over the Transport
+type Operation interface {
+	Run(ctx context.Context, t transport.Transport, host transport.Host, session transport.Session) command.CommandOutput
+}
+
+// Preparer is an Operation that checks every host before the first one is contacted, such as
+// rendering its templates
+type Preparer interface {
+	Prepare(hosts []transport.Host) error
+}
+
+// runSession is the default Operation, running the Session as it is
+type runSession struct{}
+
+func (runSession) Run(ctx context.Context, t transport.Transport, host transport.Host, session transport.Session) command.CommandOutput {
+	return t.Run(ctx, host, session)
+}
+
+func (e *Executor) operation() Operation {
+	if e.Operation == nil {
+		return runSession{}
+	}
+	return e.Operation
+}
+
+func (e *Executor) Run(ctx context.Context, hosts []transport.Host) *Collector {
+	collector := NewCollector()
+	batches := e.Rollout.Batches(hosts)
+	for i, batch := range batches {
+		if ctx.Err() != nil {
+			for j := i; j < len(batches); j++ {
+				for _, host := range batches[j] {
+					e.collect(collector, host, CancelledResult(j+1, ctx.Err()))
+				}
+			}
+			break
+		}
+		results := e.runBatch(ctx, i+1, batch, collector)
+		// a cancelled run fails the rest of its batch, which is no reason to halt the rollout
+		if i+1 < len(batches) && ctx.Err() == nil && e.Rollout.Halt(i, results) {
+			log.Printf("rollout halted after batch %d of %d, failures exceeded %v%%", i+1, len(batches), e.Rollout.haltPercent(i))
+			for j := i + 1; j < len(batches); j++ {
+				for _, host := range batches[j] {
+					e.collect(collector, host, SkippedResult(j+1, i+1))
+				}
+			}
+			break
+		}
+	}
+	return collector
+}
+
+func (e *Executor) runBatch(ctx context.Context, batch int, hosts []transport.Host, collector *Collector) []Result {
+	results := make([]Result, len(hosts))
+	wg := sync.WaitGroup{}
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host transport.Host) {
+			defer wg.Done()
+			if e.Limit != nil {
+				e.Limit.Acquire()
+				defer e.Limit.Release()
+			}
+			var result Result
+			if ctx.Err() != nil {
+				result = CancelledResult(batch, ctx.Err())
+			} else {
+				result = e.runHost(ctx, host)
+				result.Batch = batch
+			}
+			results[i] = result
+			e.collect(collector, host, result)
+		}(i, host)
+	}
+	wg.Wait()
+	return results
+}
+
+func (e *Executor) runHost(ctx context.Context, host transport.Host) Result {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	session, flush := e.Session, func() {}
+	if e.Stream != nil {
+		session.Stdout, session.Stderr, flush = e.Stream.Writers(host.String())
+	}
+	startedAt := time.Now().UTC()
+	output := e.operation().Run(ctx, e.Transport, host, session)
+	result := NewResult(output, startedAt, time.Now().UTC())
+	flush()
+	if e.Stream != nil {
+		e.Stream.Done(host.String(), result)
+	}
+	if result.Failed() && e.Stream == nil {
+		log.Printf("failed to exec cmd on %s:\n\n%s\n\nSTDOUT = %s\nSTDERR = %s\nEXIT CODE = %d\nERROR = %s\n\n", host, result.Cmd, result.Stdout, result.Stderr, result.ExitCode, result.Error)
+	}
+	return result
+}
+
+// collect records result under host, tagged with the cluster host was discovered in
+func (e *Executor) collect(collector *Collector, host transport.Host, result Result) {
+	result.Cluster = host.Cluster
+	collector.Add(host.String(), result)
+	if e.OnResult != nil {
+		e.OnResult(host.String(), result)
+	}
+}
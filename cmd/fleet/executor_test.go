@@ -0,0 +1,158 @@
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	sema "github.com/andreimerlescu/go-sema"
+)
+
+// fakeTransport answers every session in memory, failing the hosts in fail, and records the
+// session of each host and how many sessions were in flight at once
+type fakeTransport struct {
+	fail     map[string]bool
+	delay    time.Duration
+	mu       sync.Mutex
+	ran      []string
+	sessions map[string]transport.Session
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (f *fakeTransport) Name() string {
+	return "fake"
+}
+
+func (f *fakeTransport) Close() error {
+	return nil
+}
+
+func (f *fakeTransport) Run(ctx context.Context, host transport.Host, session transport.Session) command.CommandOutput {
+	current := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if current <= peak || f.peak.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	f.mu.Lock()
+	f.ran = append(f.ran, host.String())
+	if f.sessions == nil {
+		f.sessions = make(map[string]transport.Session)
+	}
+	f.sessions[host.String()] = session
+	f.mu.Unlock()
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return command.CommandOutput{Command: session.Command, ExitCode: -1, Error: ctx.Err()}
+		}
+	}
+	if f.fail[host.String()] {
+		return command.CommandOutput{Command: session.Command, ExitCode: 1, Stderr: []byte("failed\n")}
+	}
+	return command.CommandOutput{Command: session.Command, Stdout: []byte(fmt.Sprintf("hello from %s\n", host))}
+}
+
+func fakeHosts(n int) []transport.Host {
+	hosts := make([]transport.Host, n)
+	for i := range hosts {
+		hosts[i] = transport.Host{Address: fmt.Sprintf("10.0.%d.%d", i/256, i%256), Index: i}
+	}
+	return hosts
+}
+
+// TestExecutorRun fans a session out to hundreds of hosts at once, run it with -race
+func TestExecutorRun(t *testing.T) {
+	const hostCount = 500
+	fake := &fakeTransport{delay: time.Millisecond}
+	executor := Executor{Transport: fake, Session: transport.Session{Command: "hostname"}}
+	collector := executor.Run(context.Background(), fakeHosts(hostCount))
+	if got := collector.Len(); got != hostCount {
+		t.Fatalf("Collector.Len() = %d, want %d", got, hostCount)
+	}
+	for host, result := range collector.Results() {
+		if result.Status != StatusOK || result.Stdout != fmt.Sprintf("hello from %s\n", host) {
+			t.Errorf("result of %s = %+v", host, result)
+		}
+	}
+	if got := len(collector.Hosts()); got != hostCount {
+		t.Errorf("len(Collector.Hosts()) = %d, want %d", got, hostCount)
+	}
+}
+
+func TestExecutorLimit(t *testing.T) {
+	const limit = 8
+	fake := &fakeTransport{delay: 2 * time.Millisecond}
+	executor := Executor{Transport: fake, Limit: sema.New(limit)}
+	collector := executor.Run(context.Background(), fakeHosts(100))
+	if got := collector.Len(); got != 100 {
+		t.Fatalf("Collector.Len() = %d, want 100", got)
+	}
+	if peak := fake.peak.Load(); peak > limit {
+		t.Errorf("%d sessions were in flight at once, want at most %d", peak, limit)
+	}
+}
+
+func TestExecutorTimeout(t *testing.T) {
+	fake := &fakeTransport{delay: time.Second}
+	executor := Executor{Transport: fake, Timeout: 10 * time.Millisecond}
+	startedAt := time.Now()
+	results := executor.Run(context.Background(), fakeHosts(3)).Results()
+	if elapsed := time.Since(startedAt); elapsed > 500*time.Millisecond {
+		t.Errorf("Run() took %v, want the sessions killed after the timeout", elapsed)
+	}
+	for host, result := range results {
+		if result.Status != StatusTimeout {
+			t.Errorf("status of %s = %s, want %s", host, result.Status, StatusTimeout)
+		}
+	}
+}
+
+// TestExecutorCancel cancels the run once the first host has finished, hosts that never started
+// are cancelled and the finished host keeps its result
+func TestExecutorCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hosts := fakeHosts(6)
+	var first string
+	executor := Executor{
+		Transport: &fakeTransport{delay: time.Millisecond},
+		Limit:     sema.New(1),
+		Rollout:   Rollout{BatchSize: 3},
+		OnResult: func(host string, result Result) {
+			if len(first) == 0 {
+				first = host
+				cancel()
+			}
+		},
+	}
+	results := executor.Run(ctx, hosts).Results()
+	if got := len(results); got != len(hosts) {
+		t.Fatalf("%d results, want %d", got, len(hosts))
+	}
+	if result := results[first]; result.Status != StatusOK || result.Stdout != fmt.Sprintf("hello from %s\n", first) {
+		t.Errorf("result of the finished host %s = %+v, want it kept", first, result)
+	}
+	for _, host := range hosts {
+		result := results[host.String()]
+		if host.String() == first {
+			continue
+		}
+		if result.Status != StatusCancelled || !strings.HasPrefix(result.Error, "never started") {
+			t.Errorf("result of %s = %+v, want it cancelled before starting", host, result)
+		}
+	}
+	if batch := results[hosts[5].String()].Batch; batch != 2 {
+		t.Errorf("batch of a host in the second batch = %d, want 2", batch)
+	}
+}
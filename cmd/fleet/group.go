@@ -0,0 +1,100 @@
+package fleet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OutputGroup is one distinct output of a run, the stdout, stderr, exit code and error that every
+// one of its Hosts produced
+type OutputGroup struct {
+	Hash     string   `json:"hash" yaml:"hash"`
+	Hosts    []string `json:"hosts" yaml:"hosts"`
+	Stdout   string   `json:"stdout" yaml:"stdout"`
+	Stderr   string   `json:"stderr" yaml:"stderr"`
+	ExitCode int      `json:"exit_code" yaml:"exit_code"`
+	Error    string   `json:"error" yaml:"error"`
+	Status   string   `json:"status" yaml:"status"`
+}
+
+// outputHash identifies what a host produced, regardless of when and how long it ran
+func outputHash(r Result) string {
+	sum := sha256.New()
+	for _, part := range []string{r.Stdout, r.Stderr, fmt.Sprint(r.ExitCode), r.Error} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:12]
+}
+
+// GroupOutputs folds the results of hosts into their distinct outputs, the most common first, and
+// hosts in the order given within each group
+func GroupOutputs(hosts []string, results map[string]Result) []OutputGroup {
+	var groups []OutputGroup
+	index := make(map[string]int)
+	for _, host := range hosts {
+		result, ok := results[host]
+		if !ok {
+			continue
+		}
+		hash := outputHash(result)
+		i, seen := index[hash]
+		if !seen {
+			i = len(groups)
+			index[hash] = i
+			groups = append(groups, OutputGroup{
+				Hash:     hash,
+				Stdout:   result.Stdout,
+				Stderr:   result.Stderr,
+				ExitCode: result.ExitCode,
+				Error:    result.Error,
+				Status:   result.Status,
+			})
+		}
+		groups[i].Hosts = append(groups[i].Hosts, host)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].Hosts) > len(groups[j].Hosts)
+	})
+	return groups
+}
+
+func (g OutputGroup) Header(total int) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Output %s on %d of %d hosts:\n---------------------\n", g.Hash, len(g.Hosts), total))
+	sb.WriteString(fmt.Sprintf("Hosts: %s\n", strings.Join(g.Hosts, ", ")))
+	sb.WriteString(fmt.Sprintf("Status: %s\n", g.Status))
+	sb.WriteString(fmt.Sprintf("Exit Code: %d\n", g.ExitCode))
+	if len(g.Error) > 0 {
+		sb.WriteString(fmt.Sprintf("Error: %s\n", g.Error))
+	}
+	if len(g.Stderr) > 0 {
+		sb.WriteString(fmt.Sprintf("Stderr:\n%s\n", strings.TrimRight(g.Stderr, "\n")))
+	}
+	return sb.String()
+}
+
+// Outliers summarises the hosts whose output differs from the most common one
+func Outliers(groups []OutputGroup) string {
+	if len(groups) == 0 {
+		return "Summary: no hosts\n"
+	}
+	total := 0
+	for _, group := range groups {
+		total += len(group.Hosts)
+	}
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Summary: %d distinct outputs across %d hosts, %d hosts share output %s\n",
+		len(groups), total, len(groups[0].Hosts), groups[0].Hash))
+	if len(groups) == 1 {
+		return sb.String()
+	}
+	sb.WriteString("Outliers:\n")
+	for _, group := range groups[1:] {
+		sb.WriteString(fmt.Sprintf("  %s (%s, exit %d): %s\n", group.Hash, group.Status, group.ExitCode, strings.Join(group.Hosts, ", ")))
+	}
+	return sb.String()
+}
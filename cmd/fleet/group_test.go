@@ -0,0 +1,111 @@
+package fleet
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupOutputs(t *testing.T) {
+	startedAt := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	ok := func(stdout string, took time.Duration) Result {
+		return Result{Stdout: stdout, Status: StatusOK, StartedAt: startedAt, FinishedAt: startedAt.Add(took), DurationMs: took.Milliseconds()}
+	}
+	failed := Result{Stdout: "ubuntu 22.04\n", Stderr: "apt lock\n", ExitCode: 100, Status: StatusFailed}
+	tests := []struct {
+		name    string
+		hosts   []string
+		results map[string]Result
+		want    [][]string
+	}{
+		{
+			name:  "identical output",
+			hosts: []string{"web1", "web2", "web3"},
+			results: map[string]Result{
+				"web1": ok("ubuntu 22.04\n", time.Second),
+				"web2": ok("ubuntu 22.04\n", 2*time.Second),
+				"web3": ok("ubuntu 22.04\n", 3*time.Second),
+			},
+			want: [][]string{{"web1", "web2", "web3"}},
+		},
+		{
+			name:  "stdout and exit code",
+			hosts: []string{"db1", "web1", "web2", "web3", "web4"},
+			results: map[string]Result{
+				"db1":  ok("ubuntu 20.04\n", time.Second),
+				"web1": ok("ubuntu 22.04\n", time.Second),
+				"web2": failed,
+				"web3": ok("ubuntu 22.04\n", time.Second),
+				"web4": ok("ubuntu 22.04\n", time.Second),
+			},
+			want: [][]string{{"web1", "web3", "web4"}, {"db1"}, {"web2"}},
+		},
+		{
+			name:  "errors",
+			hosts: []string{"web1", "web2", "web3"},
+			results: map[string]Result{
+				"web1": {ExitCode: -1, Error: "dial tcp: i/o timeout", Status: StatusError},
+				"web2": {ExitCode: -1, Error: "context deadline exceeded", Status: StatusTimeout},
+				"web3": {ExitCode: -1, Error: "dial tcp: i/o timeout", Status: StatusError},
+			},
+			want: [][]string{{"web1", "web3"}, {"web2"}},
+		},
+		{
+			name:    "hosts without results",
+			hosts:   []string{"web1", "web2"},
+			results: map[string]Result{"web2": ok("up\n", time.Second)},
+			want:    [][]string{{"web2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := GroupOutputs(tt.hosts, tt.results)
+			var got [][]string
+			for _, group := range groups {
+				got = append(got, group.Hosts)
+				want := tt.results[group.Hosts[0]]
+				if group.Stdout != want.Stdout || group.Stderr != want.Stderr || group.ExitCode != want.ExitCode || group.Status != want.Status {
+					t.Errorf("GroupOutputs() group %s = %+v, want the output of %s", group.Hash, group, group.Hosts[0])
+				}
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("GroupOutputs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutliers(t *testing.T) {
+	results := map[string]Result{
+		"web1": {Stdout: "ok\n", Status: StatusOK},
+		"web2": {Stdout: "ok\n", Status: StatusOK},
+		"web3": {Stdout: "ok\n", Status: StatusOK},
+		"db1":  {Stdout: "ok\n", Stderr: "warn\n", Status: StatusOK},
+		"db2":  {ExitCode: 2, Status: StatusFailed},
+	}
+	tests := []struct {
+		name  string
+		hosts []string
+		want  []string
+	}{
+		{name: "no hosts", want: []string{"Summary: no hosts\n"}},
+		{name: "one output", hosts: []string{"web1", "web2", "web3"}, want: []string{
+			"Summary: 1 distinct outputs across 3 hosts, 3 hosts share output " + outputHash(results["web1"]) + "\n",
+		}},
+		{name: "outliers", hosts: []string{"db2", "web1", "db1", "web2", "web3"}, want: []string{
+			"Summary: 3 distinct outputs across 5 hosts, 3 hosts share output " + outputHash(results["web1"]) + "\n",
+			"Outliers:\n",
+			"  " + outputHash(results["db2"]) + " (failed, exit 2): db2\n",
+			"  " + outputHash(results["db1"]) + " (ok, exit 0): db1\n",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Outliers(GroupOutputs(tt.hosts, results))
+			if want := strings.Join(tt.want, ""); got != want {
+				t.Errorf("Outliers() = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package fleet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/data"
+)
+
+var unsafeFileNameRegex = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Logs appends every run's per-host output to the --stdout and --stderr files and, when RunDir
+// is set, also writes one <host>.stdout and <host>.stderr file per host inside of it
+type Logs struct {
+	RunID  string
+	Stdout string
+	Stderr string
+	RunDir string
+}
+
+func NewRunID() string {
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405Z"), strings.ToLower(data.RandomString(6)))
+}
+
+func (l Logs) Write(hosts []string, results map[string]Result) error {
+	if err := l.appendAll(l.Stdout, hosts, results, func(r Result) string { return r.Stdout }); err != nil {
+		return err
+	}
+	if err := l.appendAll(l.Stderr, hosts, results, func(r Result) string { return r.Stderr }); err != nil {
+		return err
+	}
+	if len(l.RunDir) == 0 {
+		return nil
+	}
+	if !command.CreateDirectory(l.RunDir) {
+		return fmt.Errorf("cannot create run directory %s", l.RunDir)
+	}
+	names := fileNames(hosts)
+	for _, host := range hosts {
+		result := results[host]
+		name := names[host]
+		if err := l.appendAll(filepath.Join(l.RunDir, name+".stdout"), []string{host}, results, func(r Result) string { return r.Stdout }); err != nil {
+			return err
+		}
+		if len(result.Stderr) == 0 {
+			continue
+		}
+		if err := l.appendAll(filepath.Join(l.RunDir, name+".stderr"), []string{host}, results, func(r Result) string { return r.Stderr }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileNames gives every host a file name of its own. Hosts whose names only differ in unsafe
+// characters or in case, such as "web (10.0.0.1)" and "web_10.0.0.1_", are numbered in order.
+func fileNames(hosts []string) map[string]string {
+	names := make(map[string]string, len(hosts))
+	taken := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		base := unsafeFileNameRegex.ReplaceAllString(host, "_")
+		name := base
+		for n := 2; taken[strings.ToLower(name)]; n++ {
+			name = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken[strings.ToLower(name)] = true
+		names[host] = name
+	}
+	return names
+}
+
+func (l Logs) appendAll(path string, hosts []string, results map[string]Result, output func(Result) string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	if dir := filepath.Dir(path); !command.CreateDirectory(dir) {
+		return fmt.Errorf("cannot create log directory %s", dir)
+	}
+	f, openErr := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if openErr != nil {
+		return openErr
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	for _, host := range hosts {
+		result := results[host]
+		body := output(result)
+		if len(body) > 0 && !strings.HasSuffix(body, "\n") {
+			body += "\n"
+		}
+		if _, err := fmt.Fprintf(f, "%s%s\n", l.header(host, result), body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Logs) header(host string, r Result) string {
+	return fmt.Sprintf("==> run=%s host=%s started_at=%s finished_at=%s exit_code=%d status=%s <==\n# %s\n",
+		l.RunID, host, r.StartedAt.Format(time.RFC3339Nano), r.FinishedAt.Format(time.RFC3339Nano), r.ExitCode, r.Status, r.Cmd)
+}
@@ -0,0 +1,61 @@
+package fleet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileNames(t *testing.T) {
+	hosts := []string{"web (10.0.0.1)", "web_10.0.0.1_", "Web_10.0.0.1_", "web_10.0.0.1_-2", "prod/db1"}
+	want := []string{"web_10.0.0.1_", "web_10.0.0.1_-2", "Web_10.0.0.1_-3", "web_10.0.0.1_-2-2", "prod_db1"}
+	names := fileNames(hosts)
+	for i, host := range hosts {
+		if names[host] != want[i] {
+			t.Errorf("fileNames()[%q] = %s, want %s", host, names[host], want[i])
+		}
+	}
+}
+
+func TestLogsWrite(t *testing.T) {
+	dir := t.TempDir()
+	logs := Logs{
+		RunID:  "run-1",
+		Stdout: filepath.Join(dir, "all.stdout"),
+		Stderr: filepath.Join(dir, "all.stderr"),
+		RunDir: filepath.Join(dir, "run-1"),
+	}
+	hosts := []string{"web (10.0.0.1)", "web_10.0.0.1_"}
+	results := map[string]Result{
+		hosts[0]: {Cmd: "hostname", Stdout: "first", Status: StatusOK},
+		hosts[1]: {Cmd: "hostname", Stdout: "second\n", Stderr: "warning\n", ExitCode: 1, Status: StatusFailed},
+	}
+	if writeErr := logs.Write(hosts, results); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	read := func(name string) string {
+		t.Helper()
+		content, readErr := os.ReadFile(filepath.Join(dir, name))
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		return string(content)
+	}
+	if all := read("all.stdout"); !strings.Contains(all, "first\n") || !strings.Contains(all, "second\n") {
+		t.Errorf("all.stdout = %q, want the stdout of both hosts", all)
+	}
+	first := read(filepath.Join("run-1", "web_10.0.0.1_.stdout"))
+	if !strings.HasPrefix(first, "==> run=run-1 host=web (10.0.0.1) ") || !strings.HasSuffix(first, "# hostname\nfirst\n\n") {
+		t.Errorf("stdout of %s = %q", hosts[0], first)
+	}
+	if second := read(filepath.Join("run-1", "web_10.0.0.1_-2.stdout")); !strings.Contains(second, "host=web_10.0.0.1_ ") || !strings.Contains(second, "second\n") {
+		t.Errorf("stdout of %s = %q", hosts[1], second)
+	}
+	if stderr := read(filepath.Join("run-1", "web_10.0.0.1_-2.stderr")); !strings.Contains(stderr, "exit_code=1 status=failed") || !strings.Contains(stderr, "warning\n") {
+		t.Errorf("stderr of %s = %q", hosts[1], stderr)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "run-1", "web_10.0.0.1_.stderr")); statErr == nil {
+		t.Errorf("a host without stderr has a .stderr file")
+	}
+}
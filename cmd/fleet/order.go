@@ -0,0 +1,118 @@
+package fleet
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+const (
+	SortDiscovery = "discovery"
+	SortIP        = "ip"
+	SortLabel     = "label"
+	SortStatus    = "status"
+	SortDuration  = "duration"
+)
+
+// statusRank puts the hosts that need attention first when sorting by status
+var statusRank = map[string]int{
+	StatusFailed:    0,
+	StatusError:     1,
+	StatusTimeout:   2,
+	StatusCancelled: 3,
+	StatusSkipped:   4,
+	StatusOK:        5,
+}
+
+// Sort orders hosts, named as transport.Host.String() names them, by the position they were
+// discovered in, by their address with IPs compared numerically, by their label with runs of
+// digits compared as numbers so node-2 comes before node-10, by the status of their result with
+// failures first, or by the duration of their result with the slowest first. Hosts that tie stay
+// in the order they were discovered in.
+func Sort(hosts []string, by string, discovered []transport.Host, results map[string]Result) ([]string, error) {
+	position := make(map[string]int, len(discovered))
+	address := make(map[string]string, len(discovered))
+	for i, host := range discovered {
+		position[host.String()] = i
+		address[host.String()] = host.Address
+	}
+	var less func(a, b string) bool
+	switch by {
+	case SortDiscovery:
+		less = func(a, b string) bool {
+			return position[a] < position[b]
+		}
+	case SortIP:
+		less = func(a, b string) bool {
+			return lessAddress(address[a], address[b])
+		}
+	case SortLabel:
+		less = lessNatural
+	case SortStatus:
+		less = func(a, b string) bool {
+			return statusRank[results[a].Status] < statusRank[results[b].Status]
+		}
+	case SortDuration:
+		less = func(a, b string) bool {
+			return results[a].DurationMs > results[b].DurationMs
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sort order %s, valid options are: %s, %s, %s, %s, %s",
+			by, SortDiscovery, SortIP, SortLabel, SortStatus, SortDuration)
+	}
+	sorted := append([]string{}, hosts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return position[sorted[i]] < position[sorted[j]]
+	})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return less(sorted[i], sorted[j])
+	})
+	return sorted, nil
+}
+
+// lessAddress puts IPv4 before IPv6 before hostnames, comparing IPs numerically
+func lessAddress(a, b string) bool {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ipA.Less(ipB)
+	case errA == nil || errB == nil:
+		return errA == nil
+	default:
+		return lessNatural(a, b)
+	}
+}
+
+// lessNatural compares a and b character by character, except that runs of digits are compared
+// by their value
+func lessNatural(a, b string) bool {
+	for len(a) > 0 && len(b) > 0 {
+		digitsA, digitsB := digitRun(a), digitRun(b)
+		if digitsA > 0 && digitsB > 0 {
+			numA, _ := strconv.ParseUint(a[:digitsA], 10, 64)
+			numB, _ := strconv.ParseUint(b[:digitsB], 10, 64)
+			if numA != numB {
+				return numA < numB
+			}
+			a, b = a[digitsA:], b[digitsB:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
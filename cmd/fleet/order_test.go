@@ -0,0 +1,93 @@
+package fleet
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+func TestSort(t *testing.T) {
+	discovered := []transport.Host{
+		{Address: "10.0.0.10", Label: "web10"},
+		{Address: "10.0.0.9", Label: "web9"},
+		{Address: "db.internal", Label: "db"},
+		{Address: "10.0.0.2", Label: "web2"},
+		{Address: "fd00::1", Label: "web2-v6"},
+	}
+	results := map[string]Result{
+		"web10":   {Status: StatusOK, DurationMs: 900},
+		"web9":    {Status: StatusFailed, DurationMs: 100},
+		"db":      {Status: StatusTimeout, DurationMs: 5000},
+		"web2":    {Status: StatusOK, DurationMs: 100},
+		"web2-v6": {Status: StatusError, DurationMs: 0},
+	}
+	// finished is the order hosts finished in, which Sort must not depend on
+	finished := []string{"web2", "web2-v6", "db", "web9", "web10"}
+	tests := []struct {
+		by   string
+		want []string
+	}{
+		{by: SortDiscovery, want: []string{"web10", "web9", "db", "web2", "web2-v6"}},
+		{by: SortIP, want: []string{"web2", "web9", "web10", "web2-v6", "db"}},
+		{by: SortLabel, want: []string{"db", "web2", "web2-v6", "web9", "web10"}},
+		{by: SortStatus, want: []string{"web9", "web2-v6", "db", "web10", "web2"}},
+		{by: SortDuration, want: []string{"db", "web10", "web9", "web2", "web2-v6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.by, func(t *testing.T) {
+			got, sortErr := Sort(finished, tt.by, discovered, results)
+			if sortErr != nil {
+				t.Fatalf("Sort() error = %v", sortErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Sort(%s) = %v, want %v", tt.by, got, tt.want)
+			}
+		})
+	}
+	t.Run("unknown", func(t *testing.T) {
+		if _, sortErr := Sort(finished, "name", discovered, results); sortErr == nil || !strings.Contains(sortErr.Error(), "unsupported sort order name") {
+			t.Errorf("Sort(name) error = %v, want an unsupported sort order error", sortErr)
+		}
+	})
+}
+
+func TestLessNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "web2", b: "web10", want: true},
+		{a: "web10", b: "web2", want: false},
+		{a: "web02", b: "web2", want: false},
+		{a: "node-2-b", b: "node-2-a", want: false},
+		{a: "node", b: "node-1", want: true},
+		{a: "a10b2", b: "a10b10", want: true},
+		{a: "web", b: "web", want: false},
+		{a: "99999999999999999999", b: "1", want: false},
+	}
+	for _, tt := range tests {
+		if got := lessNatural(tt.a, tt.b); got != tt.want {
+			t.Errorf("lessNatural(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLessAddress(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "10.0.0.9", b: "10.0.0.10", want: true},
+		{a: "192.168.0.1", b: "10.0.0.1", want: false},
+		{a: "10.0.0.1", b: "::1", want: true},
+		{a: "::1", b: "host1", want: true},
+		{a: "host2", b: "host10", want: true},
+	}
+	for _, tt := range tests {
+		if got := lessAddress(tt.a, tt.b); got != tt.want {
+			t.Errorf("lessAddress(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package fleet
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+	FormatJUnit = "junit"
+)
+
+// Formats are the structured formats a Report can be written in
+var Formats = []string{FormatJSON, FormatJSONL, FormatYAML, FormatCSV, FormatJUnit}
+
+// Totals counts the hosts of a run by status
+type Totals struct {
+	Hosts     int `json:"hosts" yaml:"hosts"`
+	OK        int `json:"ok" yaml:"ok"`
+	Failed    int `json:"failed" yaml:"failed"`
+	Error     int `json:"error" yaml:"error"`
+	Timeout   int `json:"timeout" yaml:"timeout"`
+	Cancelled int `json:"cancelled" yaml:"cancelled"`
+	Skipped   int `json:"skipped" yaml:"skipped"`
+}
+
+func (t *Totals) add(status string) {
+	t.Hosts++
+	switch status {
+	case StatusOK:
+		t.OK++
+	case StatusFailed:
+		t.Failed++
+	case StatusError:
+		t.Error++
+	case StatusTimeout:
+		t.Timeout++
+	case StatusCancelled:
+		t.Cancelled++
+	case StatusSkipped:
+		t.Skipped++
+	}
+}
+
+// Report is the envelope of a run written by the structured formats: what ran, when, how many
+// hosts ended in each status, and the Result of every host. Groups is set with --group-output.
+type Report struct {
+	RunID      string            `json:"run_id" yaml:"run_id"`
+	Command    string            `json:"command" yaml:"command"`
+	StartedAt  time.Time         `json:"started_at" yaml:"started_at"`
+	FinishedAt time.Time         `json:"finished_at" yaml:"finished_at"`
+	Totals     Totals            `json:"totals" yaml:"totals"`
+	Hosts      map[string]Result `json:"hosts" yaml:"hosts"`
+	Groups     []OutputGroup     `json:"groups,omitempty" yaml:"groups,omitempty"`
+	order      []string
+}
+
+// NewReport is the Report of results, with hosts in the order they should be written
+func NewReport(runID, cmd string, startedAt, finishedAt time.Time, hosts []string, results map[string]Result) Report {
+	report := Report{
+		RunID:      runID,
+		Command:    cmd,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Hosts:      results,
+		order:      hosts,
+	}
+	for _, host := range hosts {
+		report.Totals.add(results[host].Status)
+	}
+	return report
+}
+
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(r)
+	case FormatJSONL:
+		return r.writeJSONL(w)
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if encodeErr := encoder.Encode(r); encodeErr != nil {
+			return encodeErr
+		}
+		return encoder.Close()
+	case FormatCSV:
+		return r.writeCSV(w)
+	case FormatJUnit:
+		return r.writeJUnit(w)
+	default:
+		return fmt.Errorf("unsupported format %s, valid options are: %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// jsonlResult is one line of FormatJSONL, a Result tagged with its run and host
+type jsonlResult struct {
+	RunID string `json:"run_id"`
+	Host  string `json:"host"`
+	Result
+}
+
+func (r Report) writeJSONL(w io.Writer) error {
+	lines := NewJSONL(w, r.RunID)
+	for _, host := range r.order {
+		if writeErr := lines.Write(host, r.Hosts[host]); writeErr != nil {
+			return writeErr
+		}
+	}
+	return nil
+}
+
+// JSONL writes FormatJSONL one host at a time, so a run can emit each line as soon as the Result
+// of its host is collected instead of after every host has finished
+type JSONL struct {
+	runID   string
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewJSONL(w io.Writer, runID string) *JSONL {
+	return &JSONL{runID: runID, encoder: json.NewEncoder(w)}
+}
+
+// Write encodes the line of host, it is safe to call from concurrent host sessions
+func (j *JSONL) Write(host string, result Result) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.encoder.Encode(jsonlResult{RunID: j.runID, Host: host, Result: result})
+}
+
+func (r Report) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"run_id", "host", "cluster", "batch", "status", "exit_code", "duration_ms", "started_at", "finished_at", "cmd", "stdout", "stderr", "error"}
+	if writeErr := writer.Write(header); writeErr != nil {
+		return writeErr
+	}
+	for _, host := range r.order {
+		result := r.Hosts[host]
+		record := []string{
+			r.RunID,
+			host,
+			result.Cluster,
+			fmt.Sprint(result.Batch),
+			result.Status,
+			fmt.Sprint(result.ExitCode),
+			fmt.Sprint(result.DurationMs),
+			timestamp(result.StartedAt),
+			timestamp(result.FinishedAt),
+			result.Cmd,
+			result.Stdout,
+			result.Stderr,
+			result.Error,
+		}
+		if writeErr := writer.Write(record); writeErr != nil {
+			return writeErr
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	ID        string      `xml:"id,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes one testcase per host, so CI systems show a fleet check as test results. A
+// non-zero exit is a failure, an unreachable, timed out or cancelled host an error, and a host
+// the rollout never reached is skipped.
+func (r Report) writeJUnit(w io.Writer) error {
+	suite := junitSuite{
+		Name:      r.Command,
+		ID:        r.RunID,
+		Tests:     r.Totals.Hosts,
+		Failures:  r.Totals.Failed,
+		Errors:    r.Totals.Error + r.Totals.Timeout + r.Totals.Cancelled,
+		Skipped:   r.Totals.Skipped,
+		Time:      seconds(r.FinishedAt.Sub(r.StartedAt)),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+	for _, host := range r.order {
+		result := r.Hosts[host]
+		classname := "fleet"
+		if len(result.Cluster) > 0 {
+			classname = result.Cluster
+		}
+		testcase := junitCase{
+			Name:      host,
+			Classname: classname,
+			Time:      seconds(time.Duration(result.DurationMs) * time.Millisecond),
+			SystemOut: result.Stdout,
+			SystemErr: result.Stderr,
+		}
+		switch result.Status {
+		case StatusOK:
+		case StatusFailed:
+			testcase.Failure = &junitMessage{Message: fmt.Sprintf("exit code %d", result.ExitCode), Type: result.Status, Text: result.Stderr}
+		case StatusSkipped:
+			testcase.Skipped = &junitMessage{Message: result.Error}
+		default:
+			testcase.Error = &junitMessage{Message: result.Error, Type: result.Status}
+		}
+		suite.Cases = append(suite.Cases, testcase)
+	}
+	suites := junitSuites{
+		Name:     r.RunID,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	if _, headerErr := io.WriteString(w, xml.Header); headerErr != nil {
+		return headerErr
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if encodeErr := encoder.Encode(suites); encodeErr != nil {
+		return encodeErr
+	}
+	_, newlineErr := io.WriteString(w, "\n")
+	return newlineErr
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
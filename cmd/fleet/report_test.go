@@ -0,0 +1,201 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	"gopkg.in/yaml.v3"
+)
+
+func testReport() Report {
+	startedAt := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	results := map[string]Result{
+		"web1": {Cmd: "uptime", Stdout: "up 3 days\n", Status: StatusOK, Batch: 1, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second), DurationMs: 1000},
+		"web2": {Cmd: "uptime", Stderr: "boom\n", ExitCode: 1, Status: StatusFailed, Batch: 1},
+		"db1":  {Cmd: "uptime", Error: "dial tcp: i/o timeout", ExitCode: -1, Status: StatusError, Batch: 1},
+		"db2":  {Error: "rollout halted after batch 1", ExitCode: -1, Status: StatusSkipped, Batch: 2},
+	}
+	return NewReport("run-1", "uptime", startedAt, startedAt.Add(2*time.Second), []string{"web1", "web2", "db1", "db2"}, results)
+}
+
+func TestReportTotals(t *testing.T) {
+	want := Totals{Hosts: 4, OK: 1, Failed: 1, Error: 1, Skipped: 1}
+	if got := testReport().Totals; got != want {
+		t.Errorf("Totals = %+v, want %+v", got, want)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	var out bytes.Buffer
+	if writeErr := testReport().Write(&out, FormatJSON); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	var decoded Report
+	if jsonErr := json.Unmarshal(out.Bytes(), &decoded); jsonErr != nil {
+		t.Fatalf("json output does not decode: %v\n%s", jsonErr, out.String())
+	}
+	if decoded.RunID != "run-1" || decoded.Command != "uptime" || decoded.Totals.Hosts != 4 || decoded.Hosts["web2"].ExitCode != 1 {
+		t.Errorf("json report = %+v", decoded)
+	}
+}
+
+func TestReportYAML(t *testing.T) {
+	var out bytes.Buffer
+	if writeErr := testReport().Write(&out, FormatYAML); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	var decoded Report
+	if yamlErr := yaml.Unmarshal(out.Bytes(), &decoded); yamlErr != nil {
+		t.Fatalf("yaml output does not decode: %v\n%s", yamlErr, out.String())
+	}
+	if decoded.RunID != "run-1" || decoded.Totals.Failed != 1 || decoded.Hosts["web1"].Stdout != "up 3 days\n" {
+		t.Errorf("yaml report = %+v", decoded)
+	}
+}
+
+func TestReportJSONL(t *testing.T) {
+	var out bytes.Buffer
+	if writeErr := testReport().Write(&out, FormatJSONL); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var hosts []string
+	for _, line := range lines {
+		var decoded jsonlResult
+		if jsonErr := json.Unmarshal([]byte(line), &decoded); jsonErr != nil {
+			t.Fatalf("jsonl line does not decode: %v\n%s", jsonErr, line)
+		}
+		if decoded.RunID != "run-1" {
+			t.Errorf("line %s has run_id %q, want run-1", line, decoded.RunID)
+		}
+		hosts = append(hosts, decoded.Host)
+	}
+	if got := strings.Join(hosts, ","); got != "web1,web2,db1,db2" {
+		t.Errorf("jsonl hosts = %s, want them in report order", got)
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	var out bytes.Buffer
+	if writeErr := testReport().Write(&out, FormatCSV); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	records, csvErr := csv.NewReader(&out).ReadAll()
+	if csvErr != nil {
+		t.Fatalf("csv output does not parse: %v", csvErr)
+	}
+	if len(records) != 5 || records[0][1] != "host" {
+		t.Fatalf("csv output = %v, want a header and 4 rows", records)
+	}
+	if web2 := records[2]; web2[1] != "web2" || web2[4] != StatusFailed || web2[5] != "1" || web2[11] != "boom\n" {
+		t.Errorf("csv row of web2 = %v", web2)
+	}
+	if records[1][7] != "2024-08-01T12:00:00Z" || records[2][7] != "" {
+		t.Errorf("csv started_at = %q and %q, want a timestamp and empty for no start", records[1][7], records[2][7])
+	}
+}
+
+func TestReportJUnit(t *testing.T) {
+	var out bytes.Buffer
+	if writeErr := testReport().Write(&out, FormatJUnit); writeErr != nil {
+		t.Fatalf("Write() error = %v", writeErr)
+	}
+	var decoded junitSuites
+	if xmlErr := xml.Unmarshal(out.Bytes(), &decoded); xmlErr != nil {
+		t.Fatalf("junit output does not decode: %v\n%s", xmlErr, out.String())
+	}
+	suite := decoded.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 1 || suite.Time != "2.000" {
+		t.Errorf("junit suite = %+v", suite)
+	}
+	cases := suite.Cases
+	if cases[0].Failure != nil || cases[1].Failure == nil || cases[2].Error == nil || cases[3].Skipped == nil {
+		t.Errorf("junit testcases = %+v, want ok, failure, error and skipped", cases)
+	}
+}
+
+func TestReportUnsupportedFormat(t *testing.T) {
+	if writeErr := testReport().Write(&bytes.Buffer{}, "toml"); writeErr == nil {
+		t.Errorf("Write(toml) error = nil, want an error")
+	}
+}
+
+// syncBuffer is a bytes.Buffer that the executor's host sessions can write to at once
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+// gateTransport holds the session of Address last until gate is closed
+type gateTransport struct {
+	last string
+	gate chan struct{}
+}
+
+func (g gateTransport) Name() string {
+	return "gate"
+}
+
+func (g gateTransport) Close() error {
+	return nil
+}
+
+func (g gateTransport) Run(ctx context.Context, host transport.Host, session transport.Session) command.CommandOutput {
+	if host.Address == g.last {
+		select {
+		case <-g.gate:
+		case <-time.After(5 * time.Second):
+			return command.CommandOutput{ExitCode: -1, Error: context.DeadlineExceeded}
+		}
+	}
+	return command.CommandOutput{Command: session.Command}
+}
+
+// TestExecutorJSONL writes the line of every host while the slowest one is still running
+func TestExecutorJSONL(t *testing.T) {
+	var out syncBuffer
+	lines := NewJSONL(&out, "run-1")
+	hosts := fakeHosts(50)
+	gate := gateTransport{last: hosts[0].Address, gate: make(chan struct{})}
+	var written atomic.Int32
+	executor := Executor{
+		Transport: gate,
+		OnResult: func(host string, result Result) {
+			if writeErr := lines.Write(host, result); writeErr != nil {
+				t.Errorf("JSONL.Write() error = %v", writeErr)
+			}
+			if written.Add(1) == int32(len(hosts)-1) {
+				close(gate.gate)
+			}
+		},
+	}
+	collector := executor.Run(context.Background(), hosts)
+	if got := strings.Count(out.buf.String(), "\n"); got != collector.Len() {
+		t.Errorf("wrote %d jsonl lines, want one per host, %d", got, collector.Len())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.buf.String()), "\n") {
+		var decoded jsonlResult
+		if jsonErr := json.Unmarshal([]byte(line), &decoded); jsonErr != nil || decoded.RunID != "run-1" || decoded.Status != StatusOK {
+			t.Errorf("jsonl line %s = %+v, %v", line, decoded, jsonErr)
+		}
+	}
+	if last := strings.Split(strings.TrimSpace(out.buf.String()), "\n")[len(hosts)-1]; !strings.Contains(last, hosts[0].Address) {
+		t.Errorf("last jsonl line = %s, want the held back host %s", last, hosts[0].Address)
+	}
+}
@@ -0,0 +1,113 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+)
+
+const (
+	StatusOK        = "ok"
+	StatusFailed    = "failed"
+	StatusError     = "error"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+	StatusSkipped   = "skipped"
+)
+
+type Result struct {
+	Cmd        string    `json:"cmd" yaml:"cmd"`
+	Stdout     string    `json:"stdout" yaml:"stdout"`
+	Stderr     string    `json:"stderr" yaml:"stderr"`
+	ExitCode   int       `json:"exit_code" yaml:"exit_code"`
+	DurationMs int64     `json:"duration_ms" yaml:"duration_ms"`
+	StartedAt  time.Time `json:"started_at" yaml:"started_at"`
+	FinishedAt time.Time `json:"finished_at" yaml:"finished_at"`
+	Error      string    `json:"error" yaml:"error"`
+	Batch      int       `json:"batch" yaml:"batch"`
+	Status     string    `json:"status" yaml:"status"`
+	Cluster    string    `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+}
+
+func NewResult(output command.CommandOutput, startedAt, finishedAt time.Time) Result {
+	result := Result{
+		Cmd:        output.Command,
+		Stdout:     string(output.Stdout),
+		Stderr:     string(output.Stderr),
+		ExitCode:   output.ExitCode,
+		DurationMs: finishedAt.Sub(startedAt).Milliseconds(),
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+	}
+	if output.Error != nil {
+		result.Error = output.Error.Error()
+	}
+	result.Status = status(output)
+	return result
+}
+
+func status(output command.CommandOutput) string {
+	switch {
+	case errors.Is(output.Error, context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.Is(output.Error, context.Canceled):
+		return StatusCancelled
+	case output.Error != nil:
+		return StatusError
+	case output.ExitCode != 0:
+		return StatusFailed
+	default:
+		return StatusOK
+	}
+}
+
+// SkippedResult marks a host in batch that never ran because the rollout halted after haltedAfter
+func SkippedResult(batch, haltedAfter int) Result {
+	return Result{
+		ExitCode: -1,
+		Error:    fmt.Sprintf("skipped, rollout halted after batch %d", haltedAfter),
+		Batch:    batch,
+		Status:   StatusSkipped,
+	}
+}
+
+// CancelledResult marks a host in batch that never ran because the run was cancelled or hit its deadline
+func CancelledResult(batch int, err error) Result {
+	result := NewResult(command.CommandOutput{ExitCode: -1, Error: fmt.Errorf("never started: %w", err)}, time.Time{}, time.Time{})
+	result.Batch = batch
+	return result
+}
+
+// Failed is true when the host was not reached, timed out, was skipped or its command exited non-zero
+func (r Result) Failed() bool {
+	return r.Status != StatusOK
+}
+
+func (r Result) started() bool {
+	return !r.StartedAt.IsZero()
+}
+
+func (r Result) Header(host string) string {
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Host %s:\n---------------------\n", host))
+	if len(r.Cluster) > 0 {
+		sb.WriteString(fmt.Sprintf("Cluster: %s\n", r.Cluster))
+	}
+	sb.WriteString(fmt.Sprintf("Command: %s\n", r.Cmd))
+	sb.WriteString(fmt.Sprintf("Batch: %d\n", r.Batch))
+	sb.WriteString(fmt.Sprintf("Status: %s\n", r.Status))
+	sb.WriteString(fmt.Sprintf("Exit Code: %d\n", r.ExitCode))
+	sb.WriteString(fmt.Sprintf("Duration: %dms\n", r.DurationMs))
+	if r.started() {
+		sb.WriteString(fmt.Sprintf("Started At: %s\n", r.StartedAt.Format(time.RFC3339Nano)))
+		sb.WriteString(fmt.Sprintf("Finished At: %s\n", r.FinishedAt.Format(time.RFC3339Nano)))
+	}
+	if len(r.Error) > 0 {
+		sb.WriteString(fmt.Sprintf("Error: %s\n", r.Error))
+	}
+	return sb.String()
+}
@@ -0,0 +1,68 @@
+package fleet
+
+import (
+	"math"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/data"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// Rollout splits hosts into waves. Canary hosts always run alone as the first wave, the
+// remaining hosts run BatchSize at a time (or BatchPercent of the hosts left after the canary
+// when set), and the rollout halts when a wave's failure percentage exceeds HaltPercent. The
+// canary wave is held to CanaryHaltPercent instead, which is zero unless set, so any failed
+// canary halts the rollout.
+type Rollout struct {
+	BatchSize         int
+	BatchPercent      float64
+	Canary            int
+	HaltPercent       float64
+	CanaryHaltPercent float64
+}
+
+const NeverHalt = 100
+
+func (r Rollout) size(total int) int {
+	if r.BatchPercent > 0 {
+		return int(math.Max(1, math.Ceil(float64(total)*r.BatchPercent/100)))
+	}
+	if r.BatchSize > 0 {
+		return r.BatchSize
+	}
+	return total
+}
+
+func (r Rollout) Batches(hosts []transport.Host) [][]transport.Host {
+	if len(hosts) == 0 {
+		return nil
+	}
+	var batches [][]transport.Host
+	if r.Canary > 0 && r.Canary < len(hosts) {
+		batches = append(batches, hosts[:r.Canary])
+		hosts = hosts[r.Canary:]
+	}
+	return append(batches, data.ChunkBy(hosts, r.size(len(hosts)))...)
+}
+
+// Halt reports whether the failures in the finished wave at index batch exceed its HaltPercent
+func (r Rollout) Halt(batch int, results []Result) bool {
+	percent := r.haltPercent(batch)
+	if len(results) == 0 || percent >= NeverHalt {
+		return false
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	return float64(failed)*100/float64(len(results)) > percent
+}
+
+// haltPercent is the failure percentage the wave at index batch may reach without halting
+func (r Rollout) haltPercent(batch int) float64 {
+	if batch == 0 && r.Canary > 0 {
+		return r.CanaryHaltPercent
+	}
+	return r.HaltPercent
+}
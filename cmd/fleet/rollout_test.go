@@ -0,0 +1,107 @@
+package fleet
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRolloutBatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		rollout Rollout
+		hosts   int
+		want    []int
+	}{
+		{name: "one batch", rollout: Rollout{}, hosts: 5, want: []int{5}},
+		{name: "batch size", rollout: Rollout{BatchSize: 2}, hosts: 5, want: []int{2, 2, 1}},
+		{name: "batch percent", rollout: Rollout{BatchPercent: 10}, hosts: 25, want: []int{3, 3, 3, 3, 3, 3, 3, 3, 1}},
+		{name: "percent overrides size", rollout: Rollout{BatchSize: 1, BatchPercent: 50}, hosts: 4, want: []int{2, 2}},
+		{name: "tiny percent", rollout: Rollout{BatchPercent: 1}, hosts: 3, want: []int{1, 1, 1}},
+		{name: "canary", rollout: Rollout{Canary: 1, BatchSize: 2}, hosts: 5, want: []int{1, 2, 2}},
+		{name: "percent of hosts after canary", rollout: Rollout{Canary: 2, BatchPercent: 50}, hosts: 10, want: []int{2, 4, 4}},
+		{name: "canary covers all", rollout: Rollout{Canary: 5}, hosts: 5, want: []int{5}},
+		{name: "no hosts", rollout: Rollout{BatchSize: 2}, hosts: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batches := tt.rollout.Batches(fakeHosts(tt.hosts))
+			var got []int
+			for _, batch := range batches {
+				got = append(got, len(batch))
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("batch sizes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("batch sizes = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRolloutHalt(t *testing.T) {
+	ok, failed := Result{Status: StatusOK}, Result{Status: StatusFailed}
+	tests := []struct {
+		name    string
+		percent float64
+		results []Result
+		want    bool
+	}{
+		{name: "never", percent: NeverHalt, results: []Result{failed, failed}, want: false},
+		{name: "at threshold", percent: 50, results: []Result{ok, failed}, want: false},
+		{name: "over threshold", percent: 49, results: []Result{ok, failed}, want: true},
+		{name: "zero tolerance", percent: 0, results: []Result{ok, ok, failed}, want: true},
+		{name: "no failures", percent: 0, results: []Result{ok, ok}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Rollout{HaltPercent: tt.percent}).Halt(1, tt.results); got != tt.want {
+				t.Errorf("Halt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRolloutHaltCanary(t *testing.T) {
+	ok, failed := Result{Status: StatusOK}, Result{Status: StatusFailed}
+	tests := []struct {
+		name    string
+		rollout Rollout
+		batch   int
+		results []Result
+		want    bool
+	}{
+		{name: "failed canary", rollout: Rollout{Canary: 2, HaltPercent: NeverHalt}, results: []Result{ok, failed}, want: true},
+		{name: "healthy canary", rollout: Rollout{Canary: 2, HaltPercent: NeverHalt}, results: []Result{ok, ok}, want: false},
+		{name: "tolerated canary", rollout: Rollout{Canary: 2, CanaryHaltPercent: 50}, results: []Result{ok, failed}, want: false},
+		{name: "after the canary", rollout: Rollout{Canary: 2, HaltPercent: NeverHalt}, batch: 1, results: []Result{failed}, want: false},
+		{name: "first batch without canary", rollout: Rollout{HaltPercent: NeverHalt}, results: []Result{failed}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rollout.Halt(tt.batch, tt.results); got != tt.want {
+				t.Errorf("Halt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutorHaltsRollout(t *testing.T) {
+	hosts := fakeHosts(6)
+	fake := &fakeTransport{fail: map[string]bool{hosts[0].String(): true}}
+	executor := Executor{Transport: fake, Rollout: Rollout{Canary: 1, BatchSize: 2, HaltPercent: NeverHalt}}
+	results := executor.Run(context.Background(), hosts).Results()
+	if got := len(results); got != len(hosts) {
+		t.Fatalf("%d results, want %d", got, len(hosts))
+	}
+	if got := len(fake.ran); got != 1 {
+		t.Errorf("%d hosts ran, want only the canary", got)
+	}
+	for _, host := range hosts[1:] {
+		if result := results[host.String()]; result.Status != StatusSkipped {
+			t.Errorf("status of %s = %s, want %s", host, result.Status, StatusSkipped)
+		}
+	}
+}
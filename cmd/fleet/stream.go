@@ -0,0 +1,108 @@
+package fleet
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+)
+
+const (
+	StreamText  = "text"
+	StreamJSONL = "jsonl"
+)
+
+const streamBufferSize = 64
+
+var streamColors = []string{"\033[36m", "\033[32m", "\033[33m", "\033[35m", "\033[34m", "\033[96m", "\033[92m", "\033[93m", "\033[95m", "\033[94m"}
+
+const streamColorReset = "\033[0m"
+
+// StreamEvent is one line of live output, or the final status of a host when Stream is "status"
+type StreamEvent struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Stream   string    `json:"stream"`
+	Line     string    `json:"line"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+}
+
+// Stream prints host output line by line as it arrives, either as "[host] line" text or as JSON lines
+type Stream struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	color  bool
+}
+
+func NewStream(out io.Writer, format string, color bool) (*Stream, error) {
+	switch format {
+	case StreamText, StreamJSONL:
+	default:
+		return nil, fmt.Errorf("unsupported stream format %s, valid options are: %s, %s", format, StreamText, StreamJSONL)
+	}
+	return &Stream{out: out, format: format, color: color && format == StreamText}, nil
+}
+
+// Writers returns the stdout and stderr writers for host, and a flush func to call once the session ends
+func (s *Stream) Writers(host string) (io.Writer, io.Writer, func()) {
+	stdout := command.NewRawLineWriter(streamBufferSize)
+	stderr := command.NewRawLineWriter(streamBufferSize)
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go s.drain(&wg, host, "stdout", stdout)
+	go s.drain(&wg, host, "stderr", stderr)
+	return stdout, stderr, func() {
+		stdout.Close()
+		stderr.Close()
+		wg.Wait()
+	}
+}
+
+func (s *Stream) drain(wg *sync.WaitGroup, host, stream string, lw *command.LineWriter) {
+	defer wg.Done()
+	for line := range lw.Lines() {
+		s.emit(StreamEvent{Time: time.Now().UTC(), Host: host, Stream: stream, Line: strings.TrimSuffix(line, "\r")})
+	}
+}
+
+// Done emits the final status of host
+func (s *Stream) Done(host string, result Result) {
+	exitCode := result.ExitCode
+	s.emit(StreamEvent{Time: time.Now().UTC(), Host: host, Stream: "status", Line: result.Status, ExitCode: &exitCode})
+}
+
+func (s *Stream) emit(event StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.format == StreamJSONL {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			return
+		}
+		_, _ = fmt.Fprintf(s.out, "%s\n", bytes)
+		return
+	}
+	prefix := fmt.Sprintf("[%s]", event.Host)
+	if event.Stream == "stderr" {
+		prefix = fmt.Sprintf("[%s stderr]", event.Host)
+	}
+	if event.Stream == "status" {
+		prefix = fmt.Sprintf("[%s exit %d]", event.Host, *event.ExitCode)
+	}
+	if s.color {
+		prefix = hostColor(event.Host) + prefix + streamColorReset
+	}
+	_, _ = fmt.Fprintf(s.out, "%s %s\n", prefix, event.Line)
+}
+
+func hostColor(host string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(host))
+	return streamColors[h.Sum32()%uint32(len(streamColors))]
+}
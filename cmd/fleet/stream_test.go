@@ -0,0 +1,28 @@
+package fleet
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStreamPassesEveryLine(t *testing.T) {
+	var out bytes.Buffer
+	stream, streamErr := NewStream(&out, StreamText, false)
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	stdout, _, flush := stream.Writers("web1")
+	for i := 0; i < 3; i++ {
+		_, _ = fmt.Fprintf(stdout, "connection %d was forcibly closed by the remote host\n", i)
+	}
+	_, _ = fmt.Fprint(stdout, "no newline")
+	flush()
+	want := "[web1] connection 0 was forcibly closed by the remote host\n" +
+		"[web1] connection 1 was forcibly closed by the remote host\n" +
+		"[web1] connection 2 was forcibly closed by the remote host\n" +
+		"[web1] no newline\n"
+	if got := out.String(); got != want {
+		t.Errorf("stream output = %q, want %q", got, want)
+	}
+}
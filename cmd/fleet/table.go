@@ -0,0 +1,84 @@
+package fleet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFirstLine keeps the output column of a Table narrow enough for a terminal
+const maxFirstLine = 60
+
+// Table is a markdown table of hosts with their status, exit code, duration and the first line of
+// their output, in the order hosts are given
+func Table(hosts []string, results map[string]Result) string {
+	header := []string{"Host", "Status", "Exit", "Duration", "Output"}
+	rows := make([][]string, 0, len(hosts))
+	for _, host := range hosts {
+		result := results[host]
+		rows = append(rows, []string{
+			host,
+			result.Status,
+			fmt.Sprint(result.ExitCode),
+			(time.Duration(result.DurationMs) * time.Millisecond).String(),
+			firstLine(result),
+		})
+	}
+	widths := make([]int, len(header))
+	for i := range header {
+		column := []string{header[i]}
+		for _, row := range rows {
+			column = append(column, row[i])
+		}
+		widths[i] = getColumnWidth(column...)
+	}
+	separator := make([]string, len(header))
+	for i, width := range widths {
+		separator[i] = strings.Repeat("-", width)
+	}
+	sb := strings.Builder{}
+	sb.WriteString(createTableRow(widths, header...))
+	sb.WriteString(createTableRow(widths, separator...))
+	for _, row := range rows {
+		sb.WriteString(createTableRow(widths, row...))
+	}
+	return sb.String()
+}
+
+// getColumnWidth is the length of the longest of values, like get_column_width in lib/functions.sh
+func getColumnWidth(values ...string) int {
+	maxLength := 0
+	for _, value := range values {
+		maxLength = max(maxLength, utf8.RuneCountInString(value))
+	}
+	return maxLength
+}
+
+// createTableRow pads each value to its column width, like create_table_row in lib/functions.sh
+func createTableRow(widths []int, values ...string) string {
+	sb := strings.Builder{}
+	sb.WriteString("|")
+	for i, value := range values {
+		sb.WriteString(fmt.Sprintf(" %s%s |", value, strings.Repeat(" ", widths[i]-utf8.RuneCountInString(value))))
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// firstLine is the first non-empty line of stdout, else of stderr, else the error of result
+func firstLine(result Result) string {
+	for _, text := range []string{result.Stdout, result.Stderr, result.Error} {
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			if runes := []rune(line); len(runes) > maxFirstLine {
+				line = string(runes[:maxFirstLine-3]) + "..."
+			}
+			return strings.ReplaceAll(line, "|", `\|`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package fleet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTable(t *testing.T) {
+	results := map[string]Result{
+		"web10": {Stdout: "\n  up 3 days  \nload 0.1\n", Status: StatusOK, DurationMs: 1500},
+		"web2":  {Stderr: "a|b\n", ExitCode: 1, Status: StatusFailed, DurationMs: 20},
+		"db":    {Error: "dial tcp: i/o timeout", ExitCode: -1, Status: StatusError},
+	}
+	want := strings.Join([]string{
+		"| Host  | Status | Exit | Duration | Output                |",
+		"| ----- | ------ | ---- | -------- | --------------------- |",
+		"| web2  | failed | 1    | 20ms     | a\\|b                  |",
+		"| web10 | ok     | 0    | 1.5s     | up 3 days             |",
+		"| db    | error  | -1   | 0s       | dial tcp: i/o timeout |",
+		"",
+	}, "\n")
+	if got := Table([]string{"web2", "web10", "db"}, results); got != want {
+		t.Errorf("Table() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFirstLine(t *testing.T) {
+	long := strings.Repeat("é", maxFirstLine+1)
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{name: "stdout", result: Result{Stdout: "\n\n hello \nworld\n", Stderr: "warn"}, want: "hello"},
+		{name: "stderr", result: Result{Stdout: " \n", Stderr: "warn\n"}, want: "warn"},
+		{name: "error", result: Result{Error: "context deadline exceeded"}, want: "context deadline exceeded"},
+		{name: "nothing", result: Result{}, want: ""},
+		{name: "long", result: Result{Stdout: long}, want: strings.Repeat("é", maxFirstLine-3) + "..."},
+		{name: "pipe", result: Result{Stdout: "a | b"}, want: `a \| b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := firstLine(tt.result); got != tt.want {
+				t.Errorf("firstLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// HostData is what a per-host Template can refer to, as in logs/{{.Host}}/syslog or
+// node-{{.Index}}. Vars are the inventory vars of the host, or the attributes of its Terraform
+// object and the outputs of --tfvars; PrivateIP and PublicIP are read from them when present.
+type HostData struct {
+	Index     int
+	Host      string
+	Label     string
+	Address   string
+	PrivateIP string
+	PublicIP  string
+	User      string
+	Port      int
+	Cluster   string
+	Vars      map[string]string
+}
+
+func NewHostData(host transport.Host) HostData {
+	vars := make(map[string]string, len(host.Vars))
+	for key, value := range host.Vars {
+		vars[key] = value
+	}
+	port := host.Port
+	if port == 0 {
+		port = transport.DefaultPort
+	}
+	return HostData{
+		Index:     host.Index,
+		Host:      host.String(),
+		Label:     host.Label,
+		Address:   host.Address,
+		PrivateIP: firstVar(vars, "private_ip", "private_ips"),
+		PublicIP:  firstVar(vars, "public_ip", "public_ips"),
+		User:      host.User,
+		Port:      port,
+		Cluster:   host.Cluster,
+		Vars:      vars,
+	}
+}
+
+func firstVar(vars map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := vars[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// Template is a text/template rendered once per host. Referring to a field that HostData does not
+// have, or to a missing key of .Vars, is an error rather than an empty string.
+type Template struct {
+	name string
+	tmpl *template.Template
+}
+
+func ParseTemplate(name, text string) (*Template, error) {
+	tmpl, parseErr := template.New(name).Option("missingkey=error").Parse(text)
+	if parseErr != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, parseErr)
+	}
+	return &Template{name: name, tmpl: tmpl}, nil
+}
+
+func (t *Template) Render(host transport.Host) (string, error) {
+	var out bytes.Buffer
+	if execErr := t.tmpl.Execute(&out, NewHostData(host)); execErr != nil {
+		return "", fmt.Errorf("failed to render %s for %s: %w", t.name, host, execErr)
+	}
+	return out.String(), nil
+}
+
+// Prepare renders t for every host, so that a mistake fails the run before any host is contacted
+func (t *Template) Prepare(hosts []transport.Host) error {
+	for _, host := range hosts {
+		if _, renderErr := t.Render(host); renderErr != nil {
+			return renderErr
+		}
+	}
+	return nil
+}
+
+// TemplatedSession is the Operation that renders the Command and Stdin of the Session it runs for
+// each host, such as hostnamectl set-hostname node-{{.Index}}
+type TemplatedSession struct {
+	command *Template
+	stdin   *Template
+}
+
+func NewTemplatedSession(session transport.Session) (*TemplatedSession, error) {
+	cmd, cmdErr := ParseTemplate("--bash", session.Command)
+	if cmdErr != nil {
+		return nil, cmdErr
+	}
+	stdin, stdinErr := ParseTemplate("--script", string(session.Stdin))
+	if stdinErr != nil {
+		return nil, stdinErr
+	}
+	return &TemplatedSession{command: cmd, stdin: stdin}, nil
+}
+
+func (s *TemplatedSession) Prepare(hosts []transport.Host) error {
+	if cmdErr := s.command.Prepare(hosts); cmdErr != nil {
+		return cmdErr
+	}
+	return s.stdin.Prepare(hosts)
+}
+
+func (s *TemplatedSession) Run(ctx context.Context, t transport.Transport, host transport.Host, session transport.Session) command.CommandOutput {
+	cmd, cmdErr := s.command.Render(host)
+	if cmdErr != nil {
+		return command.CommandOutput{Command: session.Command, ExitCode: -1, Error: cmdErr}
+	}
+	stdin, stdinErr := s.stdin.Render(host)
+	if stdinErr != nil {
+		return command.CommandOutput{Command: cmd, ExitCode: -1, Error: stdinErr}
+	}
+	session.Command = cmd
+	if len(session.Stdin) > 0 {
+		session.Stdin = []byte(stdin)
+	}
+	return t.Run(ctx, host, session)
+}
@@ -0,0 +1,148 @@
+package fleet
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	sema "github.com/andreimerlescu/go-sema"
+)
+
+func TestTemplateRender(t *testing.T) {
+	host := transport.Host{
+		Address: "10.0.0.7",
+		Label:   "prod/web-7",
+		User:    "ubuntu",
+		Index:   7,
+		Cluster: "prod",
+		Vars:    map[string]string{"private_ip": "10.0.0.7", "public_ip": "1.1.1.7", "role": "web"},
+	}
+	tests := []struct {
+		text string
+		host transport.Host
+		want string
+	}{
+		{text: "logs/{{.Host}}/syslog", host: host, want: "logs/prod/web-7/syslog"},
+		{text: "{{.Cluster}}-node-{{.Index}}", host: host, want: "prod-node-7"},
+		{text: "{{.User}}@{{.Address}}:{{.Port}}", host: host, want: "ubuntu@10.0.0.7:22"},
+		{text: "{{.PrivateIP}} {{.PublicIP}} {{.Vars.role}}", host: host, want: "10.0.0.7 1.1.1.7 web"},
+		{text: "{{.Host}} {{.Label}}[{{.Cluster}}]", host: transport.Host{Address: "10.0.0.1"}, want: "10.0.0.1 []"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			tmpl, parseErr := ParseTemplate("--dest", tt.text)
+			if parseErr != nil {
+				t.Fatalf("ParseTemplate() error = %v", parseErr)
+			}
+			got, renderErr := tmpl.Render(tt.host)
+			if renderErr != nil {
+				t.Fatalf("Render() error = %v", renderErr)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	if _, parseErr := ParseTemplate("--dest", "{{.Host"); parseErr == nil || !strings.Contains(parseErr.Error(), "invalid --dest template") {
+		t.Errorf("ParseTemplate() error = %v, want an invalid template error", parseErr)
+	}
+	hosts := []transport.Host{
+		{Address: "10.0.0.1", Vars: map[string]string{"role": "web"}},
+		{Address: "10.0.0.2"},
+	}
+	for _, text := range []string{"{{.Name}}", "{{.Vars.role}}"} {
+		tmpl, parseErr := ParseTemplate("--dest", text)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		// the second host has no role, so every host must be checked and not only the first
+		if prepareErr := tmpl.Prepare(hosts); prepareErr == nil || !strings.Contains(prepareErr.Error(), "failed to render --dest") {
+			t.Errorf("Prepare() of %s error = %v, want a render error", text, prepareErr)
+		}
+	}
+}
+
+// runTemplated prepares and runs session the way main does, returning the Prepare error
+func runTemplated(fake *fakeTransport, session transport.Session, hosts []transport.Host) error {
+	operation, parseErr := NewTemplatedSession(session)
+	if parseErr != nil {
+		return parseErr
+	}
+	if prepareErr := operation.Prepare(hosts); prepareErr != nil {
+		return prepareErr
+	}
+	executor := Executor{Transport: fake, Session: session, Limit: sema.New(2), Operation: operation}
+	executor.Run(context.Background(), hosts)
+	return nil
+}
+
+func TestTemplatedSession(t *testing.T) {
+	hosts := []transport.Host{
+		{Address: "10.0.0.1", Label: "blue/web", Cluster: "blue", Index: 0},
+		{Address: "10.0.0.1", Label: "green/web", Cluster: "green", Index: 1},
+	}
+	tests := []struct {
+		name    string
+		session transport.Session
+		want    map[string]transport.Session
+	}{
+		{
+			name:    "command",
+			session: transport.Session{Command: "hostnamectl set-hostname {{.Cluster}}-{{.Index}} # {{.Host}}"},
+			want: map[string]transport.Session{
+				"blue/web":  {Command: "hostnamectl set-hostname blue-0 # blue/web"},
+				"green/web": {Command: "hostnamectl set-hostname green-1 # green/web"},
+			},
+		},
+		{
+			name:    "script",
+			session: transport.Session{Command: "bash -s --", Stdin: []byte("echo {{.Host}} in {{.Cluster}}\n")},
+			want: map[string]transport.Session{
+				"blue/web":  {Command: "bash -s --", Stdin: []byte("echo blue/web in blue\n")},
+				"green/web": {Command: "bash -s --", Stdin: []byte("echo green/web in green\n")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTransport{}
+			if runErr := runTemplated(fake, tt.session, hosts); runErr != nil {
+				t.Fatalf("runTemplated() error = %v", runErr)
+			}
+			for host, want := range tt.want {
+				got := fake.sessions[host]
+				if got.Command != want.Command || string(got.Stdin) != string(want.Stdin) {
+					t.Errorf("%s ran %q with stdin %q, want %q with stdin %q", host, got.Command, got.Stdin, want.Command, want.Stdin)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplatedSessionPrepare(t *testing.T) {
+	hosts := []transport.Host{
+		{Address: "10.0.0.1", Vars: map[string]string{"role": "web"}},
+		{Address: "10.0.0.2"},
+	}
+	for name, session := range map[string]transport.Session{
+		"unparsable command": {Command: "echo {{.Host"},
+		"unparsable script":  {Command: "bash -s --", Stdin: []byte("{{if .Host}}")},
+		"unknown field":      {Command: "echo {{.Hostname}}"},
+		"missing var":        {Command: "echo {{.Vars.role}}"},
+		"missing script var": {Command: "bash -s --", Stdin: []byte("echo {{.Vars.role}}")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fake := &fakeTransport{}
+			if runErr := runTemplated(fake, session, hosts); runErr == nil {
+				t.Errorf("runTemplated() error = nil, want a template error")
+			}
+			if len(fake.ran) != 0 {
+				t.Errorf("runTemplated() contacted %v, want no host contacted", fake.ran)
+			}
+		})
+	}
+}
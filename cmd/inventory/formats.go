@@ -0,0 +1,155 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"gopkg.in/yaml.v3"
+)
+
+// reserved keys configure the connection, every other key of a group or host is a variable
+const (
+	keyAddress = "address"
+	keyUser    = "user"
+	keyPort    = "port"
+	keyKey     = "key"
+)
+
+// yamlGroup is one entry of the groups list in a YAML inventory:
+//
+//	groups:
+//	  - name: web
+//	    user: ec2-user
+//	    vars: {env: prod}
+//	    hosts:
+//	      - name: web1
+//	        address: 10.0.1.10
+//	        port: 2222
+//	        key: .ssh/web.pem
+//	        vars: {role: frontend}
+type yamlGroup struct {
+	Name  string            `yaml:"name"`
+	User  string            `yaml:"user"`
+	Port  int               `yaml:"port"`
+	Key   string            `yaml:"key"`
+	Vars  map[string]string `yaml:"vars"`
+	Hosts []yamlHost        `yaml:"hosts"`
+}
+
+type yamlHost struct {
+	Name    string            `yaml:"name"`
+	Address string            `yaml:"address"`
+	User    string            `yaml:"user"`
+	Port    int               `yaml:"port"`
+	Key     string            `yaml:"key"`
+	Vars    map[string]string `yaml:"vars"`
+}
+
+func loadYAML(file string) ([]group, error) {
+	bytes, readErr := os.ReadFile(file)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var doc struct {
+		Groups []yamlGroup `yaml:"groups"`
+	}
+	if yamlErr := yaml.Unmarshal(bytes, &doc); yamlErr != nil {
+		return nil, yamlErr
+	}
+	groups := make([]group, 0, len(doc.Groups))
+	for _, yg := range doc.Groups {
+		if len(yg.Name) == 0 {
+			return nil, fmt.Errorf("group is missing a name")
+		}
+		g := group{name: yg.Name, user: yg.User, port: yg.Port, keys: splitKeys(yg.Key), vars: yg.Vars}
+		for _, yh := range yg.Hosts {
+			name := yh.Name
+			if len(name) == 0 {
+				name = yh.Address
+			}
+			if len(name) == 0 {
+				return nil, fmt.Errorf("host in group %s is missing a name and address", yg.Name)
+			}
+			g.hosts = append(g.hosts, &Host{Name: name, Address: yh.Address, User: yh.User, Port: yh.Port, Keys: splitKeys(yh.Key), Vars: yh.Vars})
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// loadINI reads groups as sections and hosts as <group>.<host> child sections, where every key
+// other than address, user, port and key is a variable:
+//
+//	[web]
+//	user = ec2-user
+//	env = prod
+//
+//	[web.web1]
+//	address = 10.0.1.10
+//	role = frontend
+func loadINI(file string) ([]group, error) {
+	cfg, loadErr := ini.Load(file)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	var groups []group
+	index := make(map[string]int)
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+		if name == ini.DefaultSection {
+			continue
+		}
+		groupName, hostName, isHost := strings.Cut(name, ".")
+		if _, ok := index[groupName]; !ok {
+			index[groupName] = len(groups)
+			groups = append(groups, group{name: groupName})
+		}
+		g := &groups[index[groupName]]
+		address, user, port, keys, vars, parseErr := iniSettings(section)
+		if parseErr != nil {
+			return nil, fmt.Errorf("section [%s]: %w", name, parseErr)
+		}
+		if isHost {
+			g.hosts = append(g.hosts, &Host{Name: hostName, Address: address, User: user, Port: port, Keys: keys, Vars: vars})
+			continue
+		}
+		g.user, g.port, g.keys, g.vars = user, port, keys, vars
+	}
+	return groups, nil
+}
+
+func iniSettings(section *ini.Section) (address, user string, port int, keys []string, vars map[string]string, err error) {
+	vars = make(map[string]string)
+	for _, key := range section.Keys() {
+		switch key.Name() {
+		case keyAddress:
+			address = key.String()
+		case keyUser:
+			user = key.String()
+		case keyPort:
+			port, err = strconv.Atoi(key.String())
+			if err != nil {
+				return "", "", 0, nil, nil, fmt.Errorf("invalid port %s", key.String())
+			}
+		case keyKey:
+			keys = splitKeys(key.String())
+		default:
+			vars[key.Name()] = key.String()
+		}
+	}
+	return address, user, port, keys, vars, nil
+}
+
+func splitKeys(csv string) []string {
+	var keys []string
+	for _, key := range strings.Split(csv, ",") {
+		key = strings.TrimSpace(key)
+		if len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,168 @@
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// GroupPrefix restricts a pattern to group names, as in group:db
+const GroupPrefix = "group:"
+
+// Host is a named target from the inventory. User, Port and Keys fall back to the values of its
+// groups, and Vars holds the group variables overridden by the host's own.
+type Host struct {
+	Name    string
+	Address string
+	User    string
+	Port    int
+	Keys    []string
+	Vars    map[string]string
+	Groups  []string
+}
+
+// Inventory holds the hosts of every group in the order they were first listed in the file
+type Inventory struct {
+	Hosts []*Host
+	names map[string]*Host
+}
+
+// group is one parsed group, before its settings are folded into its hosts
+type group struct {
+	name  string
+	user  string
+	port  int
+	keys  []string
+	vars  map[string]string
+	hosts []*Host
+}
+
+// Load reads a YAML (.yaml, .yml) or INI inventory file
+func Load(file string) (*Inventory, error) {
+	var groups []group
+	var loadErr error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		groups, loadErr = loadYAML(file)
+	default:
+		groups, loadErr = loadINI(file)
+	}
+	if loadErr != nil {
+		return nil, fmt.Errorf("failed to load inventory %s: %w", file, loadErr)
+	}
+	return build(groups)
+}
+
+func build(groups []group) (*Inventory, error) {
+	inv := &Inventory{names: make(map[string]*Host)}
+	for _, g := range groups {
+		for _, h := range g.hosts {
+			host, seen := inv.names[h.Name]
+			if !seen {
+				host = &Host{Name: h.Name, Vars: make(map[string]string)}
+				inv.names[h.Name] = host
+				inv.Hosts = append(inv.Hosts, host)
+			}
+			host.Groups = append(host.Groups, g.name)
+			for k, v := range g.vars {
+				host.Vars[k] = v
+			}
+			host.merge(g.user, g.port, g.keys, "")
+		}
+	}
+	// host settings and vars win over those of every group, so they are applied after all groups
+	for _, g := range groups {
+		for _, h := range g.hosts {
+			host := inv.names[h.Name]
+			host.override(h)
+			for k, v := range h.Vars {
+				host.Vars[k] = v
+			}
+		}
+	}
+	for _, host := range inv.Hosts {
+		if len(host.Address) == 0 {
+			host.Address = host.Name
+		}
+	}
+	if len(inv.Hosts) == 0 {
+		return nil, fmt.Errorf("inventory has no hosts")
+	}
+	return inv, nil
+}
+
+func (h *Host) merge(user string, port int, keys []string, address string) {
+	if len(user) > 0 {
+		h.User = user
+	}
+	if port > 0 {
+		h.Port = port
+	}
+	if len(keys) > 0 {
+		h.Keys = keys
+	}
+	if len(address) > 0 {
+		h.Address = address
+	}
+}
+
+func (h *Host) override(from *Host) {
+	h.merge(from.User, from.Port, from.Keys, from.Address)
+}
+
+// Select returns the hosts matching any of patterns, in inventory order. A group:<glob> pattern
+// matches group names, any other glob matches a group name, host name or address. No patterns
+// selects every host.
+func (i *Inventory) Select(patterns []string) ([]*Host, error) {
+	if len(patterns) == 0 {
+		return i.Hosts, nil
+	}
+	for _, pattern := range patterns {
+		if _, matchErr := path.Match(strings.TrimPrefix(pattern, GroupPrefix), ""); matchErr != nil {
+			return nil, fmt.Errorf("invalid host pattern %s: %w", pattern, matchErr)
+		}
+	}
+	var selected []*Host
+	for _, host := range i.Hosts {
+		for _, pattern := range patterns {
+			if host.matches(pattern) {
+				selected = append(selected, host)
+				break
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no inventory hosts match %s", strings.Join(patterns, ","))
+	}
+	return selected, nil
+}
+
+func (h *Host) matches(pattern string) bool {
+	groupPattern, groupOnly := strings.CutPrefix(pattern, GroupPrefix)
+	for _, g := range h.Groups {
+		if ok, _ := path.Match(groupPattern, g); ok {
+			return true
+		}
+	}
+	if groupOnly {
+		return false
+	}
+	for _, name := range []string{h.Name, h.Address} {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Target converts the inventory host into a transport.Host, using defaultUser when no user is set
+func (h *Host) Target(defaultUser string) transport.Host {
+	user := h.User
+	if len(user) == 0 {
+		user = defaultUser
+	}
+	return transport.Host{Label: h.Name, Address: h.Address, Port: h.Port, User: user, Keys: h.Keys, Vars: h.Vars}
+}
@@ -0,0 +1,133 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const yamlInventory = `groups:
+  - name: web
+    user: ec2-user
+    vars: {role: frontend, env: prod}
+    hosts:
+      - name: web1
+        address: 10.0.1.10
+        port: 2222
+        vars: {tier: gold}
+      - name: web2
+        address: 10.0.1.11
+  - name: all
+    user: admin
+    port: 22
+    vars: {role: generic, tier: bronze}
+    hosts:
+      - name: web1
+      - name: db1
+        address: 10.0.2.10
+        user: postgres
+`
+
+const iniInventory = `[web]
+user = ec2-user
+role = frontend
+env = prod
+
+[web.web1]
+address = 10.0.1.10
+port = 2222
+tier = gold
+
+[web.web2]
+address = 10.0.1.11
+
+[all]
+user = admin
+port = 22
+role = generic
+tier = bronze
+
+[all.web1]
+
+[all.db1]
+address = 10.0.2.10
+user = postgres
+`
+
+func writeInventory(t *testing.T, name, text string) *Inventory {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if writeErr := os.WriteFile(file, []byte(text), 0600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	inv, loadErr := Load(file)
+	if loadErr != nil {
+		t.Fatalf("Load(%s) error = %v", name, loadErr)
+	}
+	return inv
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	for _, name := range []string{"hosts.yaml", "hosts.ini"} {
+		t.Run(name, func(t *testing.T) {
+			text := yamlInventory
+			if strings.HasSuffix(name, ".ini") {
+				text = iniInventory
+			}
+			inv := writeInventory(t, name, text)
+			if got := len(inv.Hosts); got != 3 {
+				t.Fatalf("len(Hosts) = %d, want 3", got)
+			}
+			web1 := inv.names["web1"]
+			// the later group wins over the earlier one, the host's own settings win over both
+			if web1.Vars["role"] != "generic" || web1.Vars["env"] != "prod" || web1.Vars["tier"] != "gold" {
+				t.Errorf("web1 vars = %v, want role generic, env prod and its own tier gold", web1.Vars)
+			}
+			if web1.User != "admin" || web1.Port != 2222 || web1.Address != "10.0.1.10" {
+				t.Errorf("web1 = %s@%s:%d, want admin@10.0.1.10:2222", web1.User, web1.Address, web1.Port)
+			}
+			if strings.Join(web1.Groups, ",") != "web,all" {
+				t.Errorf("web1 groups = %v, want [web all]", web1.Groups)
+			}
+			if db1 := inv.names["db1"]; db1.User != "postgres" || db1.Vars["tier"] != "bronze" {
+				t.Errorf("db1 = %+v, want its own user and the group tier", db1)
+			}
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	inv := writeInventory(t, "hosts.yaml", yamlInventory)
+	tests := []struct {
+		patterns []string
+		want     string
+	}{
+		{patterns: nil, want: "web1,web2,db1"},
+		{patterns: []string{"web"}, want: "web1,web2"},
+		{patterns: []string{"group:all"}, want: "web1,db1"},
+		{patterns: []string{"web*"}, want: "web1,web2"},
+		{patterns: []string{"10.0.2.*"}, want: "db1"},
+		{patterns: []string{"db1", "web2"}, want: "web2,db1"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.patterns, ","), func(t *testing.T) {
+			hosts, selectErr := inv.Select(tt.patterns)
+			if selectErr != nil {
+				t.Fatalf("Select(%v) error = %v", tt.patterns, selectErr)
+			}
+			var names []string
+			for _, host := range hosts {
+				names = append(names, host.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("Select(%v) = %s, want %s", tt.patterns, got, tt.want)
+			}
+		})
+	}
+	for _, patterns := range [][]string{{"group:web1"}, {"nothing"}, {"[web"}} {
+		if _, selectErr := inv.Select(patterns); selectErr == nil {
+			t.Errorf("Select(%v) error = nil, want an error", patterns)
+		}
+	}
+}
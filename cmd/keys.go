@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	"golang.org/x/term"
+)
+
+func splitCSV(csv string) []string {
+	var out []string
+	for _, field := range strings.Split(csv, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) > 0 {
+			out = append(out, field)
+		}
+	}
+	return out
+}
+
+// keyFiles are the candidate private keys from --key, tried in order
+func (c *config) keyFiles() []string {
+	return splitCSV(*c.key)
+}
+
+// keyMap parses --keymap, a CSV of host=path pairs where a host may be listed more than once
+func (c *config) keyMap() (map[string][]string, error) {
+	keys := make(map[string][]string)
+	for _, pair := range splitCSV(*c.keyMapCSV) {
+		host, path, found := strings.Cut(pair, "=")
+		if !found || len(host) == 0 || len(path) == 0 {
+			return nil, fmt.Errorf("invalid --keymap entry %q, expected host=path", pair)
+		}
+		keys[host] = append(keys[host], path)
+	}
+	return keys, nil
+}
+
+func (c *config) usingAgent() bool {
+	return *c.agent && len(os.Getenv("SSH_AUTH_SOCK")) > 0
+}
+
+// passphrase reads the passphrase of an encrypted key from --key-passphrase-env, or prompts for it on a terminal
+func (c *config) passphrase(path string) ([]byte, error) {
+	if value, ok := os.LookupEnv(*c.keyPassEnv); ok {
+		return []byte(value), nil
+	}
+	if !isTerminal(os.Stdin) {
+		return nil, fmt.Errorf("ssh key %s is encrypted, set %s or run from a terminal", path, *c.keyPassEnv)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, readErr := term.ReadPassword(int(os.Stdin.Fd()))
+	_, _ = fmt.Fprintln(os.Stderr)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read passphrase for %s: %w", path, readErr)
+	}
+	return passphrase, nil
+}
+
+func (c *config) newKeyring() (*transport.Keyring, error) {
+	return transport.NewKeyring(*c.agent, c.passphrase)
+}
@@ -3,16 +3,24 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
 
 	"github.com/andreimerlescu/configurable"
 	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/discovery"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/inventory"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
 	sema "github.com/andreimerlescu/go-sema"
 )
 
@@ -32,6 +40,54 @@ type config struct {
 	stdout      *string
 	ipCSV       *string
 	tfOutputVar *string
+	transport   *string
+	pty         *bool
+	failOn      *string
+	failPercent *float64
+	parallel    *int
+	serial      *bool
+	batch       *int
+	batchPct    *float64
+	canary      *int
+	haltPct     *float64
+	timeout     *time.Duration
+	deadline    *time.Duration
+	stream      *bool
+	streamFmt   *string
+	groupOutput *bool
+	format      *string
+	table       *bool
+	sortBy      *string
+	logDir      *string
+	perHostLogs *bool
+	hostKeyMode *string
+	knownHosts  *string
+	tfHostKeys  *string
+	tfLabels    *string
+	tfVars      *string
+	template    *bool
+	tfSource    *string
+	tfState     *string
+	tfResource  *string
+	tfTag       *string
+	tfStateName *string
+	workspace   *string
+	put         *string
+	get         *string
+	dest        *string
+	script      *string
+	scriptArgs  *string
+	scriptLib   *string
+	scriptMode  *string
+	state       *discovery.State
+	backend     httpBackend
+	agent       *bool
+	keyPassEnv  *string
+	keyMapCSV   *string
+	jump        *string
+	inventory   *string
+	group       *string
+	sections    fileSections
 }
 
 func commonValidator(co command.CommandOutput) bool {
@@ -44,81 +100,420 @@ func commonValidator(co command.CommandOutput) bool {
 
 const defaultTerraformState = "default-tfstate"
 
+const defaultParallel = 32
+
+const defaultTFOutputVar = "public_ips"
+
+// terraformStateName is --tf-state-name, or derived from the basename of --tfdir and the workspace
 func (c *config) terraformStateName() string {
+	if len(*c.tfStateName) > 0 {
+		return *c.tfStateName
+	}
 	dirInfo, dirErr := os.Lstat(*c.tfDir)
 	if dirErr != nil {
 		log.Printf("terraformStateName() dirErr = %v", dirErr)
 		return defaultTerraformState
 	}
+	if c.hasWorkspace() {
+		return fmt.Sprintf("%s-%s-tfstate", dirInfo.Name(), *c.workspace)
+	}
 	return fmt.Sprintf("%s-tfstate", dirInfo.Name())
 }
 
 func (c *config) getEnv() []string {
-	stateName := c.terraformStateName()
-	address := fmt.Sprintf("%s/projects/%d/terraform/state/%s", *c.api, *c.projectId, stateName)
-	env := os.Environ()
-	env = append(env, fmt.Sprintf("%s=%s", "TF_STATE_NAME", stateName))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_USERNAME", *c.user))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_PASSWORD", *c.accessToken))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_ADDRESS", address))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_LOCK_ADDRESS", fmt.Sprintf("%s/lock", address)))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_UNLOCK_ADDRESS", fmt.Sprintf("%s/unlock", address)))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_LOCK_METHOD", `"POST"`))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_UNLOCK_METHOD", `"DELETE"`))
-	env = append(env, fmt.Sprintf("%s=%s", "TF_HTTP_RETRY_WAIT_MIN", `5`))
+	env := append(os.Environ(), c.backend.env()...)
+	if c.hasWorkspace() {
+		env = append(env, fmt.Sprintf("%s=%s", "TF_WORKSPACE", *c.workspace))
+	}
 	return env
 }
 
-func (c *config) terraformPublicIPs() []string {
-	var cmd string
-	if *c.tfOutputVar == "public_ips" {
-		// use JSON for public_ips
-		cmd = fmt.Sprintf("%s=%s %s %s", "terraform -chdir", *c.tfDir, "output -json", *c.tfOutputVar)
-	} else if *c.tfOutputVar == "public_ip" {
-		cmd = fmt.Sprintf("%s=%s %s %s", "terraform -chdir", *c.tfDir, "output", *c.tfOutputVar)
+// terraformHosts reads every output named in --tfoutputvar, whatever its shape, and labels the
+// hosts from --tflabelvar when set. Objects prefer their private address when jumping.
+func (c *config) terraformHosts(jumps []transport.Host) ([]transport.Host, error) {
+	addressKeys := discovery.PublicAddressKeys
+	if len(jumps) > 0 {
+		addressKeys = discovery.PrivateAddressKeys
+	}
+	var hosts []transport.Host
+	if c.isUsingState() {
+		state, stateErr := c.terraformState()
+		if stateErr != nil {
+			return nil, stateErr
+		}
+		var hostsErr error
+		filter, filterErr := c.stateFilter()
+		if filterErr != nil {
+			return nil, filterErr
+		}
+		hosts, hostsErr = state.Hosts(splitCSV(*c.tfOutputVar), filter, addressKeys)
+		if hostsErr != nil {
+			return nil, hostsErr
+		}
 	} else {
-		log.Fatalf("Cannot use --tfoutputvar=%s here. Valid options are: public_ip, public_ips", *c.tfOutputVar)
+		var outputs [][]transport.Host
+		for _, name := range splitCSV(*c.tfOutputVar) {
+			stdout, outputErr := c.terraformOutputJSON(name)
+			if outputErr != nil {
+				return nil, outputErr
+			}
+			output, parseErr := discovery.ParseOutput(name, stdout, addressKeys)
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			outputs = append(outputs, output)
+		}
+		hosts = discovery.Merge(outputs...)
 	}
+	if len(*c.tfLabels) == 0 {
+		return hosts, nil
+	}
+	labels, labelsErr := c.terraformAddressMap(*c.tfLabels, hosts)
+	if labelsErr != nil {
+		return nil, labelsErr
+	}
+	for i, host := range hosts {
+		if label, ok := labels[host.Address]; ok {
+			hosts[i].Label = label
+		}
+	}
+	return hosts, nil
+}
+
+func (c *config) terraformOutputJSON(name string) ([]byte, error) {
+	if c.isUsingState() {
+		return c.stateOutputJSON(name)
+	}
+	cmd := fmt.Sprintf("%s=%s %s %s", "terraform -chdir", *c.tfDir, "output -json", name)
 	cmdOutput, cmdOk := command.Prompt().RunInside(c.ctx, cmd, c.limit, *c.tfDir, c.getEnv(), commonValidator)
 	if !cmdOk {
 		log.Println(cmd)
-		log.Printf("terraformPublicIPs() cmdOutput !ok\n\nSTDERR = %s\n\nSTDOUT = %s\n", cmdOutput.Stderr, cmdOutput.Stdout)
-		return []string{}
+		log.Printf("terraformOutputJSON() cmdOutput !ok\n\nSTDERR = %s\n\nSTDOUT = %s\n", cmdOutput.Stderr, cmdOutput.Stdout)
+		return nil, fmt.Errorf("terraform output %s failed: %v", name, cmdOutput.Error)
+	}
+	return cmdOutput.Stdout, nil
+}
+
+// terraformAddressMap reads output name, either a map of address to value or a list of values in
+// the same order as hosts, such as the host keys of --tfhostkeysvar or the labels of --tflabelvar
+func (c *config) terraformAddressMap(name string, hosts []transport.Host) (map[string]string, error) {
+	values := make(map[string]string)
+	stdout, outputErr := c.terraformOutputJSON(name)
+	if outputErr != nil {
+		return nil, outputErr
+	}
+	if mapErr := json.Unmarshal(stdout, &values); mapErr == nil {
+		return values, nil
+	}
+	var list []string
+	if listErr := json.Unmarshal(stdout, &list); listErr != nil {
+		return nil, fmt.Errorf("terraform output %s must be a map of address to value or a list of values: %w", name, listErr)
+	}
+	if len(list) != len(hosts) {
+		return nil, fmt.Errorf("terraform output %s has %d values for %d hosts", name, len(list), len(hosts))
+	}
+	for i, host := range hosts {
+		values[host.Address] = list[i]
+	}
+	return values, nil
+}
+
+// terraformHostKeys pins the host keys of --tfhostkeysvar onto hosts
+func (c *config) terraformHostKeys(hosts []transport.Host) error {
+	if len(*c.tfHostKeys) == 0 {
+		return nil
+	}
+	hostKeys, hostKeysErr := c.terraformAddressMap(*c.tfHostKeys, hosts)
+	if hostKeysErr != nil {
+		return hostKeysErr
+	}
+	for i, host := range hosts {
+		hosts[i].HostKey = hostKeys[host.Address]
+	}
+	return nil
+}
+
+// terraformVars adds the outputs of --tfvars to the Vars of hosts, by output name
+func (c *config) terraformVars(hosts []transport.Host) error {
+	for _, name := range splitCSV(*c.tfVars) {
+		values, valuesErr := c.terraformAddressMap(name, hosts)
+		if valuesErr != nil {
+			return valuesErr
+		}
+		for i, host := range hosts {
+			value, ok := values[host.Address]
+			if !ok {
+				continue
+			}
+			if hosts[i].Vars == nil {
+				hosts[i].Vars = make(map[string]string)
+			}
+			hosts[i].Vars[name] = value
+		}
+	}
+	return nil
+}
+
+// jumpHosts is the ProxyJump chain from --jump, or from the jump_hosts section of config.yaml
+func (c *config) jumpHosts() ([]transport.Host, error) {
+	var hops []transport.Host
+	for _, spec := range splitCSV(*c.jump) {
+		hop, parseErr := transport.ParseHost(spec, *c.user)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid --jump %s: %w", spec, parseErr)
+		}
+		hops = append(hops, hop)
+	}
+	if len(hops) > 0 {
+		return hops, nil
+	}
+	for _, section := range c.sections.JumpHosts {
+		hop := transport.Host{User: section.User, Address: section.Address, Port: section.Port}
+		if len(hop.User) == 0 {
+			hop.User = *c.user
+		}
+		if len(hop.Address) == 0 {
+			return nil, fmt.Errorf("jump_hosts entry in config.yaml is missing an address")
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// usePrivateIPs switches discovery to private_ips when targets are reached through a jump host
+// and --tfoutputvar was left at its default
+func (c *config) usePrivateIPs(jumps []transport.Host) {
+	if len(jumps) == 0 || *c.tfOutputVar != defaultTFOutputVar || isFlagSet("tfoutputvar") {
+		return
+	}
+	log.Printf("using --tfoutputvar private_ips since targets are reached through --jump %s", transport.JumpSpec(jumps))
+	*c.tfOutputVar = "private_ips"
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func (c *config) newHostKeys() (*transport.HostKeys, error) {
+	return transport.NewHostKeys(*c.hostKeyMode, *c.knownHosts)
+}
+
+func (c *config) newTransport(hostKeys *transport.HostKeys, jumps []transport.Host) (transport.Transport, error) {
+	switch *c.transport {
+	case transport.NameNative:
+		keyring, keyringErr := c.newKeyring()
+		if keyringErr != nil {
+			return nil, keyringErr
+		}
+		t, err := transport.NewSSH(transport.SSHOptions{KeyFiles: c.keyFiles(), Keyring: keyring, HostKeys: hostKeys, Jumps: jumps})
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	case transport.NameExec:
+		options := transport.DefaultExecOptions
+		if c.usingAgent() {
+			options = ""
+		}
+		return transport.NewExec(transport.ExecOptions{
+			Options:   options,
+			KeyFiles:  c.keyFiles(),
+			Directory: ".",
+			Env:       os.Environ(),
+			Limit:     c.limit,
+			HostKeys:  hostKeys,
+			Jumps:     jumps,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported --transport %s, valid options are: %s, %s", *c.transport, transport.NameNative, transport.NameExec)
+	}
+}
+
+// hosts targets every distinct address of --ipcsv
+func (c *config) hosts(ips []string) []transport.Host {
+	hosts := make([]transport.Host, 0, len(ips))
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		hosts = append(hosts, transport.Host{Address: ip, User: *c.user})
+	}
+	return hosts
+}
+
+// inventoryHosts loads --inventory and selects the hosts matching the --group patterns
+func (c *config) inventoryHosts() ([]transport.Host, error) {
+	inv, loadErr := inventory.Load(*c.inventory)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	selected, selectErr := inv.Select(splitCSV(*c.group))
+	if selectErr != nil {
+		return nil, selectErr
+	}
+	hosts := make([]transport.Host, 0, len(selected))
+	for _, host := range selected {
+		hosts = append(hosts, host.Target(*c.user))
+	}
+	return hosts, nil
+}
+
+// targets are the hosts to run on, from --inventory, terraform or --ipcsv, and the clusters they
+// were discovered in. An error comes with the exit code to fail with, since a discovery that
+// found nothing is not a config error.
+func (c *config) targets(jumps []transport.Host) ([]transport.Host, []cluster, int, error) {
+	if len(*c.group) > 0 && len(*c.inventory) == 0 {
+		return nil, nil, exitConfigError, errors.New("--group selects hosts from an --inventory file, which is not set")
+	}
+	switch {
+	case len(*c.inventory) > 0:
+		hosts, inventoryErr := c.inventoryHosts()
+		if inventoryErr != nil {
+			return nil, nil, exitConfigError, inventoryErr
+		}
+		return hosts, nil, exitOK, nil
+	case c.isUsingTerraform():
+		clusters, clustersErr := c.clusters()
+		if clustersErr != nil {
+			return nil, nil, exitConfigError, clustersErr
+		}
+		hosts, discoveryErr := c.clusterHosts(clusters, jumps)
+		if discoveryErr != nil {
+			return nil, nil, exitDiscoveryFailed, discoveryErr
+		}
+		if len(hosts) == 0 {
+			return nil, nil, exitDiscoveryFailed, errors.New("terraform discovery returned no hosts")
+		}
+		return hosts, clusters, exitOK, nil
+	default:
+		if len(*c.ipCSV) == 0 {
+			return nil, nil, exitConfigError, errors.New("no hosts to target, --tfdir must be a terraform directory, or --ipcsv or --inventory must be set")
+		}
+		*c.ipCSV = strings.ReplaceAll(*c.ipCSV, " ", "")
+		return c.hosts(strings.Split(*c.ipCSV, ",")), nil, exitOK, nil
 	}
-	target := []string{}
-	jsonErr := json.Unmarshal(cmdOutput.Stdout, &target)
-	if jsonErr != nil {
-		log.Fatalln(jsonErr)
+}
+
+// withKeyMap prepends the --keymap keys of each host, matched by label or address
+func withKeyMap(hosts []transport.Host, keyMap map[string][]string) []transport.Host {
+	for i, host := range hosts {
+		keys := append(append([]string{}, keyMap[host.Label]...), keyMap[host.Address]...)
+		hosts[i].Keys = append(keys, host.Keys...)
+	}
+	return hosts
+}
+
+// sessionLimit bounds the number of in-flight host sessions from --parallel and --serial
+func (c *config) sessionLimit() (sema.Semaphore, error) {
+	if *c.serial {
+		return sema.New(1), nil
+	}
+	if *c.parallel < 1 {
+		return nil, fmt.Errorf("--parallel must be at least 1, got %d", *c.parallel)
+	}
+	return sema.New(*c.parallel), nil
+}
+
+func (c *config) rollout() (fleet.Rollout, error) {
+	if *c.batch < 0 {
+		return fleet.Rollout{}, fmt.Errorf("--batch cannot be negative, got %d", *c.batch)
+	}
+	if *c.batchPct < 0 || *c.batchPct > 100 {
+		return fleet.Rollout{}, fmt.Errorf("--batch-percent must be between 0 and 100, got %v", *c.batchPct)
 	}
-	return target
+	if *c.canary < 0 {
+		return fleet.Rollout{}, fmt.Errorf("--canary cannot be negative, got %d", *c.canary)
+	}
+	if *c.haltPct < 0 || *c.haltPct > fleet.NeverHalt {
+		return fleet.Rollout{}, fmt.Errorf("--halt-percent must be between 0 and 100, got %v", *c.haltPct)
+	}
+	rollout := fleet.Rollout{
+		BatchSize:    *c.batch,
+		BatchPercent: *c.batchPct,
+		Canary:       *c.canary,
+		HaltPercent:  *c.haltPct,
+	}
+	// a failed canary halts the rollout, unless --halt-percent says how many failures to tolerate
+	if isFlagSet("halt-percent") {
+		rollout.CanaryHaltPercent = *c.haltPct
+	}
+	return rollout, nil
+}
+
+func (c *config) newStream() (*fleet.Stream, error) {
+	if !*c.stream {
+		return nil, nil
+	}
+	return fleet.NewStream(os.Stdout, *c.streamFmt, isTerminal(os.Stdout))
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (c *config) logs(runID string) fleet.Logs {
+	logs := fleet.Logs{RunID: runID, Stdout: *c.stdout, Stderr: *c.stderr}
+	if *c.perHostLogs {
+		logs.RunDir = filepath.Join(*c.logDir, runID)
+	}
+	return logs
 }
 
 func (c *config) isUsingTerraform() bool {
-	dirInfo, dirErr := os.Lstat(*c.tfDir)
-	if dirErr != nil {
-		log.Printf("isUsingTerraform() dirErr = %v", dirErr)
+	if len(*c.ipCSV) > 0 || len(*c.inventory) > 0 {
 		return false
 	}
-	if !dirInfo.IsDir() {
-		log.Printf("isUsingTerraform() --tfdir is not a directory and must be")
+	if *c.tfSource == tfSourceHTTP || *c.tfSource == tfSourceFile {
+		return true
+	}
+	dirs, dirsErr := c.tfDirs()
+	if dirsErr != nil {
+		log.Printf("isUsingTerraform() dirsErr = %v", dirsErr)
 		return false
 	}
-	return len(*c.ipCSV) == 0
+	for _, dir := range dirs {
+		dirInfo, dirErr := os.Lstat(dir)
+		if dirErr != nil {
+			log.Printf("isUsingTerraform() dirErr = %v", dirErr)
+			return false
+		}
+		if !dirInfo.IsDir() {
+			log.Printf("isUsingTerraform() --tfdir %s is not a directory and must be", dir)
+			return false
+		}
+	}
+	return len(dirs) > 0
 }
 
 func (c *config) Parse() error {
 	configFile := filepath.Join(".", "config.yaml")
+	cfgErr := c.cfg.Parse("")
+	if cfgErr != nil {
+		return cfgErr
+	}
 	_, statErr := os.Stat(configFile)
-	if statErr != nil {
-		cfgErr := c.cfg.Parse("")
-		if cfgErr != nil {
-			return cfgErr
+	if statErr == nil {
+		fileErr := loadConfigFile(flag.CommandLine, configFile)
+		if fileErr != nil {
+			return fileErr
 		}
-	} else {
-		cfgErr := c.cfg.Parse(configFile)
-		if cfgErr != nil {
-			return cfgErr
+		sections, sectionsErr := loadSections(configFile)
+		if sectionsErr != nil {
+			return sectionsErr
 		}
+		c.sections = sections
 	}
 	return nil
 }
@@ -131,9 +526,14 @@ type application struct {
 }
 
 func main() {
+	// Ctrl-C cancels every in-flight session and still prints partial results, and once it has,
+	// restoring the default handling lets a second Ctrl-C terminate whatever did not stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+
 	// Create a CLI application
 	var app application = application{
-		ctx:   context.Background(),
+		ctx:   ctx,
 		cfg:   configurable.New(),
 		limit: sema.New(runtime.GOMAXPROCS(0)),
 	}
@@ -145,87 +545,218 @@ func main() {
 		limit:       app.limit,
 		api:         app.cfg.NewString("api", "https://gitlab.com/api/v4", "GitLab API URL"),
 		projectId:   app.cfg.NewInt("id", 1, "GitLab Project ID"),
-		json:        app.cfg.NewBool("json", false, "Use JSON formatted output"),
+		json:        app.cfg.NewBool("json", false, "Use JSON formatted output, same as --format json"),
 		user:        app.cfg.NewString("user", "ubuntu", "Username of remote host"),
-		key:         app.cfg.NewString("key", filepath.Join(".", ".ssh", "id_ed25519"), "Path to SSH key for remote access"),
-		tfDir:       app.cfg.NewString("tfdir", filepath.Join(".", "terraform"), "Path to terraform directory"),
+		key:         app.cfg.NewString("key", filepath.Join(".", ".ssh", "id_ed25519"), "CSV of paths to SSH keys for remote access, tried in order"),
+		tfDir:       app.cfg.NewString("tfdir", filepath.Join(".", "terraform"), "CSV of terraform directories or globs, like clusters/*, to discover hosts from"),
 		bash:        app.cfg.NewString("bash", "", "Bash command to execute remotely"),
+		script:      app.cfg.NewString("script", "", "Local script file to run on every host instead of --bash"),
+		scriptArgs:  app.cfg.NewString("script-args", "", "Arguments passed to --script, quoted like a shell command line"),
+		scriptLib:   app.cfg.NewString("script-lib", "", "CSV of helper files or globs, like lib/*.sh, sourced before --script"),
+		scriptMode:  app.cfg.NewString("script-mode", scriptModeStdin, "How --script reaches the host: stdin (piped to bash) or upload (temporary file)"),
+		put:         app.cfg.NewString("put", "", "Local file or directory to copy to --dest on every host"),
+		get:         app.cfg.NewString("get", "", "Remote file or directory to copy from every host to --dest"),
+		dest:        app.cfg.NewString("dest", "", "Destination of --put or --get, a template like logs/{{.Host}}/syslog"),
 		stdout:      app.cfg.NewString("stdout", filepath.Join(".", "logs", "go.ebs.stdout"), "Path to STDOUT to write to"),
 		stderr:      app.cfg.NewString("stderr", filepath.Join(".", "logs", "go.ebs.stderr"), "Path to STDERR to write to"),
 		ipCSV:       app.cfg.NewString("ipcsv", "", "CSV string of IP addresses"),
 		accessToken: app.cfg.NewString("token", "", "GitLab API Access Token"),
-		tfOutputVar: app.cfg.NewString("tfoutputvar", "public_ips", "Output variable name from Terraform to get IP addresses of target hosts"),
+		tfOutputVar: app.cfg.NewString("tfoutputvar", defaultTFOutputVar, "CSV of Terraform outputs holding target hosts: an address, a list, a map of label to address or objects"),
+		transport:   app.cfg.NewString("transport", transport.NameNative, "SSH transport to use: native (in-process) or exec (local ssh binary)"),
+		pty:         app.cfg.NewBool("pty", false, "Request a PTY for each remote session"),
+		failOn:      app.cfg.NewString("fail-on", failOnAny, "Exit non-zero when hosts fail: any, percent (more than --fail-percent) or all"),
+		failPercent: app.cfg.NewFloat64("fail-percent", 0, "Percentage of failed hosts tolerated when --fail-on percent"),
+		parallel:    app.cfg.NewInt("parallel", defaultParallel, "Maximum number of concurrent host sessions"),
+		serial:      app.cfg.NewBool("serial", false, "Run hosts one at a time, same as --parallel 1"),
+		batch:       app.cfg.NewInt("batch", 0, "Run hosts in rolling batches of this many hosts, 0 runs all hosts as one batch"),
+		batchPct:    app.cfg.NewFloat64("batch-percent", 0, "Run hosts in rolling batches of this percentage of the hosts after --canary, overrides --batch"),
+		canary:      app.cfg.NewInt("canary", 0, "Run this many hosts as a first batch on their own before the rest"),
+		haltPct:     app.cfg.NewFloat64("halt-percent", fleet.NeverHalt, "Halt the rollout when a batch has more than this percentage of failed hosts, a failed --canary halts unless set"),
+		timeout:     app.cfg.NewDuration("timeout", 0, "Per host timeout, after which the session is killed (0 = no timeout)"),
+		deadline:    app.cfg.NewDuration("deadline", 0, "Deadline for the whole run, after which every session is killed (0 = no deadline)"),
+		stream:      app.cfg.NewBool("stream", false, "Print each line of output prefixed by its host as it arrives"),
+		streamFmt:   app.cfg.NewString("stream-format", fleet.StreamText, "Format of --stream output: text or jsonl"),
+		table:       app.cfg.NewBool("table", false, "Print a table of hosts with their status, exit code, duration and first line of output"),
+		sortBy:      app.cfg.NewString("sort", fleet.SortDiscovery, "Order hosts are printed in: discovery, ip (numeric), label, status (failures first) or duration (slowest first)"),
+		format:      app.cfg.NewString("format", fleet.FormatText, "Output format: text, json, jsonl, yaml, csv or junit (one testcase per host)"),
+		groupOutput: app.cfg.NewBool("group-output", false, "Print each distinct output once with the hosts that produced it, and a summary of outliers"),
+		logDir:      app.cfg.NewString("logdir", filepath.Join(".", "logs"), "Directory that holds one <run-id> directory per run when --per-host-logs"),
+		perHostLogs: app.cfg.NewBool("per-host-logs", false, "Also write <logdir>/<run-id>/<host>.stdout and .stderr for every host"),
+		hostKeyMode: app.cfg.NewString("host-key-check", transport.HostKeyTOFU, "Host key verification: strict (known_hosts only), tofu (record new hosts) or off"),
+		knownHosts:  app.cfg.NewString("known-hosts", filepath.Join(".", ".ssh", "known_hosts"), "Path to the known_hosts file used to verify host keys"),
+		tfHostKeys:  app.cfg.NewString("tfhostkeysvar", "", "Output variable name from Terraform with the expected host keys of target hosts"),
+		tfSource:    app.cfg.NewString("tf-source", tfSourceCLI, "Where Terraform hosts come from: cli (terraform output), http (state from the GitLab API with --token) or file (--tf-state)"),
+		tfStateName: app.cfg.NewString("tf-state-name", "", "Name of the state in the terraform http backend, defaults to <tfdir basename>-tfstate"),
+		workspace:   app.cfg.NewString("workspace", "", "CSV of terraform workspaces to discover hosts from in every --tfdir"),
+		tfState:     app.cfg.NewString("tf-state", "", "Path to a terraform.tfstate or terraform show -json file, defaults to terraform.tfstate in --tfdir"),
+		tfResource:  app.cfg.NewString("tf-resource", "", "CSV of resource addresses in the state to target, like aws_instance.docker_member"),
+		tfTag:       app.cfg.NewString("tf-tag", "", "CSV of key=value tags, values may be globs, that targeted instances in the state must have"),
+		tfLabels:    app.cfg.NewString("tflabelvar", "", "Output variable name from Terraform with the labels of target hosts, like instance_ids"),
+		tfVars:      app.cfg.NewString("tfvars", "", "CSV of Terraform outputs, maps of address to value or lists in host order, available to --template as .Vars.<output>"),
+		template:    app.cfg.NewBool("template", false, "Render --bash and --script per host as Go templates, like node-{{.Index}}"),
+		agent:       app.cfg.NewBool("agent", true, "Authenticate with the keys held by the ssh-agent at SSH_AUTH_SOCK"),
+		keyPassEnv:  app.cfg.NewString("key-passphrase-env", "ESB_KEY_PASSPHRASE", "Environment variable holding the passphrase of encrypted SSH keys"),
+		keyMapCSV:   app.cfg.NewString("keymap", "", "CSV of host=path pairs of SSH keys to try first for specific hosts"),
+		jump:        app.cfg.NewString("jump", "", "CSV of [user@]host[:port] jump hosts, chained in order, to reach targets through"),
+		inventory:   app.cfg.NewString("inventory", "", "Path to a YAML (.yaml, .yml) or INI inventory file of host groups"),
+		group:       app.cfg.NewString("group", "", "CSV of inventory host patterns to target, like web*, db1 or group:db"),
 	}
 
 	// Parse arguments and/or config.yaml
 	cfgErr := app.config.Parse()
 	if cfgErr != nil {
-		log.Fatalln(cfgErr)
-	}
-
-	var ips []string
-	sshOpts := "-o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o CheckHostIP=no"
-	wg := sync.WaitGroup{}
-
-	type Result struct {
-		Cmd    string `json:"cmd"`
-		Stdout string `json:"stdout"`
-		Stderr string `json:"stderr"`
-	}
-	results := make(map[string]Result)
-
-	// Validations
-	if app.config.isUsingTerraform() {
-		ips = app.config.terraformPublicIPs()
-		for _, ip := range ips {
-			wg.Add(1)
-			go func(ctx context.Context, wg *sync.WaitGroup, ip string, limit sema.Semaphore) {
-				defer wg.Done()
-				cmd := fmt.Sprintf("%s %s %s %s@%s %s", "ssh -i", *app.config.key, sshOpts, *app.config.user, ip, *app.config.bash)
-				output, ok := command.Prompt().RunInside(ctx, cmd, limit, *app.config.tfDir, app.config.getEnv(), commonValidator)
-				if !ok {
-					log.Printf("failed to exec cmd:\n\n%s\n\nSTDOUT = %s\nSTDERR = %s\n\n", cmd, output.Stdout, output.Stderr)
-				}
-				results[ip] = Result{
-					Cmd:    cmd,
-					Stdout: string(output.Stdout),
-					Stderr: string(output.Stderr),
-				}
-			}(app.ctx, &wg, ip, app.limit)
-		}
-		wg.Wait()
-	} else {
-		*app.config.ipCSV = strings.ReplaceAll(*app.config.ipCSV, " ", "")
-		ips = strings.Split(*app.config.ipCSV, ",")
-		for _, ip := range ips {
-			wg.Add(1)
-			go func(ctx context.Context, wg *sync.WaitGroup, ip string, limit sema.Semaphore) {
-				defer wg.Done()
-				cmd := fmt.Sprintf("%s %s %s %s@%s %s", "ssh -i", *app.config.key, sshOpts, *app.config.user, ip, *app.config.bash)
-				output, ok := command.Prompt().RunInside(ctx, cmd, limit, *app.config.tfDir, app.config.getEnv(), commonValidator)
-				if !ok {
-					log.Printf("failed to exec cmd:\n\n%s\n\nSTDOUT = %s\nSTDERR = %s\n\n", cmd, output.Stdout, output.Stderr)
-				}
-				results[ip] = Result{
-					Cmd:    cmd,
-					Stdout: string(output.Stdout),
-					Stderr: string(output.Stderr),
-				}
-			}(app.ctx, &wg, ip, app.limit)
-		}
-		wg.Wait()
-	}
-
-	if !*app.config.json {
-		for ip, result := range results {
-			_, _ = fmt.Fprintf(os.Stdout, "Host %s:\n---------------------\nCommand: %s\n\n%s\n", ip, result.Cmd, string(result.Stdout))
+		fatal(exitConfigError, cfgErr)
+	}
+	// os.Exit skips deferred calls, so the deadline is cancelled by hand before exiting
+	cancelDeadline := context.CancelFunc(func() {})
+	if *app.config.deadline > 0 {
+		app.ctx, cancelDeadline = context.WithTimeout(app.ctx, *app.config.deadline)
+		app.config.ctx = app.ctx
+	}
+	failOnErr := app.config.validateFailOn()
+	if failOnErr != nil {
+		fatal(exitConfigError, failOnErr)
+	}
+	tfSourceErr := app.config.validateTFSource()
+	if tfSourceErr != nil {
+		fatal(exitConfigError, tfSourceErr)
+	}
+	formatErr := app.config.validateFormat()
+	if formatErr != nil {
+		fatal(exitConfigError, formatErr)
+	}
+	sortErr := app.config.validateSort()
+	if sortErr != nil {
+		fatal(exitConfigError, sortErr)
+	}
+
+	sessions, limitErr := app.config.sessionLimit()
+	if limitErr != nil {
+		fatal(exitConfigError, limitErr)
+	}
+
+	rollout, rolloutErr := app.config.rollout()
+	if rolloutErr != nil {
+		fatal(exitConfigError, rolloutErr)
+	}
+
+	stream, streamErr := app.config.newStream()
+	if streamErr != nil {
+		fatal(exitConfigError, streamErr)
+	}
+
+	session, sessionErr := app.config.session()
+	if sessionErr != nil {
+		fatal(exitConfigError, sessionErr)
+	}
+
+	operation, operationErr := app.config.operation(session)
+	if operationErr != nil {
+		fatal(exitConfigError, operationErr)
+	}
+
+	keyMap, keyMapErr := app.config.keyMap()
+	if keyMapErr != nil {
+		fatal(exitConfigError, keyMapErr)
+	}
+
+	hostKeys, hostKeysErr := app.config.newHostKeys()
+	if hostKeysErr != nil {
+		fatal(exitConfigError, hostKeysErr)
+	}
+
+	jumps, jumpsErr := app.config.jumpHosts()
+	if jumpsErr != nil {
+		fatal(exitConfigError, jumpsErr)
+	}
+	app.config.usePrivateIPs(jumps)
+
+	remote, transportErr := app.config.newTransport(hostKeys, jumps)
+	if transportErr != nil {
+		fatal(exitConfigError, transportErr)
+	}
+
+	hosts, clusters, targetsCode, targetsErr := app.config.targets(jumps)
+	if targetsErr != nil {
+		fatal(targetsCode, targetsErr)
+	}
+	hosts = withKeyMap(hosts, keyMap)
+	if labelsErr := transport.UniqueLabels(hosts); labelsErr != nil {
+		fatal(exitConfigError, labelsErr)
+	}
+	for i := range hosts {
+		hosts[i].Index = i
+	}
+	if preparer, ok := operation.(fleet.Preparer); ok {
+		if prepareErr := preparer.Prepare(hosts); prepareErr != nil {
+			fatal(exitConfigError, prepareErr)
 		}
-	} else {
-		bytes, err := json.Marshal(results)
-		if err != nil {
-			log.Fatalln(err)
+	}
+
+	executor := fleet.Executor{
+		Transport: remote,
+		Session:   session,
+		Limit:     sessions,
+		Rollout:   rollout,
+		Timeout:   *app.config.timeout,
+		Stream:    stream,
+		Operation: operation,
+	}
+	runID := fleet.NewRunID()
+	format := app.config.outputFormat()
+	if format == fleet.FormatJSONL {
+		// jsonl is written host by host as results arrive, in the order hosts finish
+		lines := fleet.NewJSONL(os.Stdout, runID)
+		executor.OnResult = func(host string, result fleet.Result) {
+			if writeErr := lines.Write(host, result); writeErr != nil {
+				log.Printf("failed to write %s output for %s: %v", format, host, writeErr)
+			}
+		}
+	}
+	startedAt := time.Now().UTC()
+	collector := executor.Run(app.ctx, hosts)
+	finishedAt := time.Now().UTC()
+	results := collector.Results()
+
+	logsErr := app.config.logs(runID).Write(collector.Hosts(), results)
+	if logsErr != nil {
+		log.Printf("failed to write logs for run %s: %v", runID, logsErr)
+	}
+
+	sorted, sortErr := fleet.Sort(collector.Hosts(), *app.config.sortBy, hosts, results)
+	if sortErr != nil {
+		fatal(exitConfigError, sortErr)
+	}
+	hostOrder := fleet.GroupByCluster(sorted, results, clusterNames(clusters))
+	switch {
+	case format == fleet.FormatJSONL:
+		// every line was already written as its host finished
+	case format != fleet.FormatText:
+		report := fleet.NewReport(runID, app.config.description(), startedAt, finishedAt, hostOrder, results)
+		if *app.config.groupOutput {
+			report.Groups = fleet.GroupOutputs(hostOrder, results)
+		}
+		if writeErr := report.Write(os.Stdout, format); writeErr != nil {
+			log.Printf("failed to write %s output: %v", format, writeErr)
+		}
+	case *app.config.groupOutput:
+		groups := fleet.GroupOutputs(hostOrder, results)
+		for _, group := range groups {
+			_, _ = fmt.Fprintf(os.Stdout, "%s\n%s\n", group.Header(len(results)), group.Stdout)
+		}
+		_, _ = fmt.Fprint(os.Stdout, fleet.Outliers(groups))
+	case *app.config.table:
+		_, _ = fmt.Fprint(os.Stdout, fleet.Table(hostOrder, results))
+	case *app.config.stream:
+		// every line was already printed as it arrived
+	default:
+		for _, host := range hostOrder {
+			result := results[host]
+			_, _ = fmt.Fprintf(os.Stdout, "%s\n%s\n", result.Header(host), result.Stdout)
 		}
-		_, _ = fmt.Fprintf(os.Stdout, "%s", string(bytes))
 	}
 
+	_ = remote.Close()
+	cancelDeadline()
+	os.Exit(app.config.exitCode(results))
 }
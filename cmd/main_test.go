@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+	sema "github.com/andreimerlescu/go-sema"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// testConfig is a config holding the defaults of every flag main defines, since the flags
+// themselves can only be registered once per process
+func testConfig() *config {
+	return &config{
+		ctx:         context.Background(),
+		limit:       sema.New(1),
+		api:         ptr("https://gitlab.com/api/v4"),
+		projectId:   ptr(1),
+		json:        ptr(false),
+		user:        ptr("ubuntu"),
+		key:         ptr(filepath.Join(".", ".ssh", "id_ed25519")),
+		tfDir:       ptr(filepath.Join(".", "terraform")),
+		bash:        ptr(""),
+		script:      ptr(""),
+		scriptArgs:  ptr(""),
+		scriptLib:   ptr(""),
+		scriptMode:  ptr(scriptModeStdin),
+		put:         ptr(""),
+		get:         ptr(""),
+		dest:        ptr(""),
+		stdout:      ptr(""),
+		stderr:      ptr(""),
+		ipCSV:       ptr(""),
+		accessToken: ptr(""),
+		tfOutputVar: ptr(defaultTFOutputVar),
+		transport:   ptr(transport.NameNative),
+		pty:         ptr(false),
+		failOn:      ptr(failOnAny),
+		failPercent: ptr(0.0),
+		parallel:    ptr(defaultParallel),
+		serial:      ptr(false),
+		batch:       ptr(0),
+		batchPct:    ptr(0.0),
+		canary:      ptr(0),
+		haltPct:     ptr(float64(fleet.NeverHalt)),
+		timeout:     ptr(time.Duration(0)),
+		deadline:    ptr(time.Duration(0)),
+		stream:      ptr(false),
+		streamFmt:   ptr(fleet.StreamText),
+		table:       ptr(false),
+		sortBy:      ptr(fleet.SortDiscovery),
+		format:      ptr(fleet.FormatText),
+		groupOutput: ptr(false),
+		logDir:      ptr(""),
+		perHostLogs: ptr(false),
+		hostKeyMode: ptr(transport.HostKeyTOFU),
+		knownHosts:  ptr(""),
+		tfHostKeys:  ptr(""),
+		tfSource:    ptr(tfSourceCLI),
+		tfStateName: ptr(""),
+		workspace:   ptr(""),
+		tfState:     ptr(""),
+		tfResource:  ptr(""),
+		tfTag:       ptr(""),
+		tfLabels:    ptr(""),
+		tfVars:      ptr(""),
+		template:    ptr(false),
+		agent:       ptr(false),
+		keyPassEnv:  ptr(""),
+		keyMapCSV:   ptr(""),
+		jump:        ptr(""),
+		inventory:   ptr(""),
+		group:       ptr(""),
+	}
+}
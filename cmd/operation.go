@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transfer"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// operation is the --put or --get file transfer, the --template rendering of session, or nil when
+// running session as it is on every host
+func (c *config) operation(session transport.Session) (fleet.Operation, error) {
+	if len(*c.put) == 0 && len(*c.get) == 0 {
+		return c.templatedSession(session)
+	}
+	if *c.template {
+		return nil, errors.New("--template renders --bash and --script, --dest of --put and --get is always a template")
+	}
+	if len(*c.put) > 0 && len(*c.get) > 0 {
+		return nil, errors.New("--put and --get cannot be used together")
+	}
+	if len(*c.bash) > 0 || len(*c.script) > 0 {
+		return nil, errors.New("--bash and --script cannot be used with --put or --get")
+	}
+	if len(*c.dest) == 0 {
+		return nil, errors.New("--dest is required with --put and --get")
+	}
+	dest, destErr := fleet.ParseTemplate("--dest", *c.dest)
+	if destErr != nil {
+		return nil, destErr
+	}
+	if len(*c.put) > 0 {
+		return transfer.NewPut(*c.put, dest)
+	}
+	source, sourceErr := fleet.ParseTemplate("--get", *c.get)
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	return &transfer.Get{Source: source, Dest: dest}, nil
+}
+
+// templatedSession renders --bash, or the --script with its --script-lib helpers, for each host
+// when --template is set
+func (c *config) templatedSession(session transport.Session) (fleet.Operation, error) {
+	if !*c.template {
+		return nil, nil
+	}
+	if len(*c.bash) == 0 && len(*c.script) == 0 {
+		return nil, errors.New("--template needs a --bash or --script to render")
+	}
+	return fleet.NewTemplatedSession(session)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+)
+
+func (c *config) validateSort() error {
+	switch *c.sortBy {
+	case fleet.SortDiscovery, fleet.SortIP, fleet.SortLabel, fleet.SortStatus, fleet.SortDuration:
+		return nil
+	default:
+		return fmt.Errorf("unsupported --sort %s, valid options are: %s, %s, %s, %s, %s",
+			*c.sortBy, fleet.SortDiscovery, fleet.SortIP, fleet.SortLabel, fleet.SortStatus, fleet.SortDuration)
+	}
+}
+
+func (c *config) validateFormat() error {
+	if *c.json && *c.format != fleet.FormatText && *c.format != fleet.FormatJSON {
+		return fmt.Errorf("--json cannot be used with --format %s", *c.format)
+	}
+	if *c.stream && c.outputFormat() != fleet.FormatText {
+		return fmt.Errorf("--stream cannot be used with --format %s, both write to stdout", c.outputFormat())
+	}
+	if *c.format == fleet.FormatText || slices.Contains(fleet.Formats, *c.format) {
+		return nil
+	}
+	return fmt.Errorf("unsupported --format %s, valid options are: %s, %s", *c.format, fleet.FormatText, strings.Join(fleet.Formats, ", "))
+}
+
+// outputFormat is --format, or json when --json is set
+func (c *config) outputFormat() string {
+	if *c.json {
+		return fleet.FormatJSON
+	}
+	return *c.format
+}
+
+// description is what the run did on every host, for the command of a structured report
+func (c *config) description() string {
+	switch {
+	case len(*c.put) > 0:
+		return fmt.Sprintf("put %s %s", *c.put, *c.dest)
+	case len(*c.get) > 0:
+		return fmt.Sprintf("get %s %s", *c.get, *c.dest)
+	case len(*c.script) > 0:
+		return strings.TrimSpace(fmt.Sprintf("%s %s", *c.script, *c.scriptArgs))
+	default:
+		return *c.bash
+	}
+}
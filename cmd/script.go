@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+const (
+	scriptModeStdin  = "stdin"
+	scriptModeUpload = "upload"
+)
+
+// scriptLibGuard is what the lib/*.sh helpers check for before they can be sourced
+const scriptLibGuard = "declare INSIDE_ESB=true\n"
+
+// session is the --bash command, or the --script to run with its --script-args
+func (c *config) session() (transport.Session, error) {
+	session := transport.Session{Command: *c.bash, PTY: *c.pty}
+	if len(*c.script) == 0 {
+		if len(*c.scriptArgs) > 0 || len(*c.scriptLib) > 0 {
+			return session, errors.New("--script-args and --script-lib need a --script")
+		}
+		return session, nil
+	}
+	if len(*c.bash) > 0 {
+		return session, errors.New("--bash and --script cannot be used together")
+	}
+	// both modes send the script over stdin, which a PTY would echo back and never close
+	if *c.pty {
+		return session, errors.New("--pty cannot be used with --script")
+	}
+	body, bodyErr := c.scriptBody()
+	if bodyErr != nil {
+		return session, bodyErr
+	}
+	args, argsErr := command.SplitFields(*c.scriptArgs)
+	if argsErr != nil {
+		return session, fmt.Errorf("invalid --script-args: %w", argsErr)
+	}
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, command.Quote(arg))
+	}
+	switch *c.scriptMode {
+	case scriptModeStdin:
+		// bash reads the script from stdin, so the script itself cannot read from it
+		session.Command = strings.TrimSpace(fmt.Sprintf("bash -s -- %s", strings.Join(quoted, " ")))
+	case scriptModeUpload:
+		interpreter := "bash "
+		if bytes.HasPrefix(body, []byte("#!")) && len(*c.scriptLib) == 0 {
+			interpreter = ""
+		}
+		session.Command = fmt.Sprintf(`tmp=$(mktemp "${TMPDIR:-/tmp}/esb-script.XXXXXX") || exit 1; cat > "$tmp" && chmod 700 "$tmp" && %s"$tmp" %s < /dev/null; rc=$?; rm -f "$tmp"; exit $rc`,
+			interpreter, strings.Join(quoted, " "))
+	default:
+		return session, fmt.Errorf("unsupported --script-mode %s, valid options are: %s, %s", *c.scriptMode, scriptModeStdin, scriptModeUpload)
+	}
+	session.Stdin = body
+	return session, nil
+}
+
+// scriptBody is the --script, preceded by the --script-lib helpers it can call
+func (c *config) scriptBody() ([]byte, error) {
+	script, readErr := os.ReadFile(*c.script)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read --script: %w", readErr)
+	}
+	libs, libsErr := c.scriptLibs()
+	if libsErr != nil {
+		return nil, libsErr
+	}
+	if len(libs) == 0 {
+		return script, nil
+	}
+	var body bytes.Buffer
+	body.WriteString(scriptLibGuard)
+	for _, lib := range libs {
+		helper, helperErr := os.ReadFile(lib)
+		if helperErr != nil {
+			return nil, fmt.Errorf("failed to read --script-lib: %w", helperErr)
+		}
+		body.WriteString(fmt.Sprintf("# --script-lib %s\n", lib))
+		body.Write(helper)
+		body.WriteString("\n")
+	}
+	body.WriteString(fmt.Sprintf("# --script %s\n", *c.script))
+	body.Write(script)
+	return body.Bytes(), nil
+}
+
+// scriptLibs expands the CSV of files and globs in --script-lib, in order
+func (c *config) scriptLibs() ([]string, error) {
+	var libs []string
+	for _, pattern := range splitCSV(*c.scriptLib) {
+		matches, globErr := filepath.Glob(pattern)
+		if globErr != nil {
+			return nil, fmt.Errorf("invalid --script-lib glob %s: %w", pattern, globErr)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("--script-lib %s matches no files", pattern)
+		}
+		libs = append(libs, matches...)
+	}
+	return libs, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scriptFiles writes every file under a temporary directory and returns its path
+func scriptFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if mkdirErr := os.MkdirAll(filepath.Dir(path), 0700); mkdirErr != nil {
+			t.Fatal(mkdirErr)
+		}
+		if writeErr := os.WriteFile(path, []byte(text), 0600); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	return dir
+}
+
+var testScripts = map[string]string{
+	"deploy.sh":       "#!/usr/bin/env bash\necho \"deploy $# $1\"\n",
+	"plain.sh":        "greet \"$1\"\n",
+	"guard.sh":        "[[ $INSIDE_ESB == true ]] || exit 9\n",
+	"lib/01-greet.sh": "greet() { echo \"hello $1\"; }\n",
+	"lib/02-retry.sh": "retry() { \"$@\"; }\n",
+}
+
+// scriptConfig is a config running script with args and the CSV of libs, all under dir
+func scriptConfig(dir, mode, script, args, libs string) *config {
+	c := testConfig()
+	*c.script, *c.scriptArgs, *c.scriptMode = filepath.Join(dir, script), args, mode
+	var paths []string
+	for _, lib := range splitCSV(libs) {
+		paths = append(paths, filepath.Join(dir, lib))
+	}
+	*c.scriptLib = strings.Join(paths, ",")
+	return c
+}
+
+func TestSession(t *testing.T) {
+	dir := scriptFiles(t, testScripts)
+	upload := func(interpreter, args string) string {
+		return `tmp=$(mktemp "${TMPDIR:-/tmp}/esb-script.XXXXXX") || exit 1; cat > "$tmp" && chmod 700 "$tmp" && ` +
+			interpreter + `"$tmp" ` + args + ` < /dev/null; rc=$?; rm -f "$tmp"; exit $rc`
+	}
+	tests := []struct {
+		name    string
+		mode    string
+		script  string
+		args    string
+		libs    string
+		command string
+		// included are the helpers the libs expand to, in order
+		included []string
+	}{
+		{name: "stdin", mode: scriptModeStdin, script: "deploy.sh", command: "bash -s --"},
+		{name: "stdin with args", mode: scriptModeStdin, script: "deploy.sh", args: `web "two words"`, command: `bash -s -- 'web' 'two words'`},
+		{name: "stdin with libs", mode: scriptModeStdin, script: "plain.sh", libs: "guard.sh,lib/*.sh", command: "bash -s --",
+			included: []string{"guard.sh", "lib/01-greet.sh", "lib/02-retry.sh"}},
+		{name: "upload runs the shebang", mode: scriptModeUpload, script: "deploy.sh", args: "web", command: upload("", "'web'")},
+		{name: "upload without a shebang", mode: scriptModeUpload, script: "plain.sh", command: upload("bash ", "")},
+		{name: "upload with libs", mode: scriptModeUpload, script: "deploy.sh", libs: "lib/02-retry.sh,lib/01-*.sh", command: upload("bash ", ""),
+			included: []string{"lib/02-retry.sh", "lib/01-greet.sh"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := scriptConfig(dir, tt.mode, tt.script, tt.args, tt.libs)
+			session, sessionErr := c.session()
+			if sessionErr != nil {
+				t.Fatalf("session() error = %v", sessionErr)
+			}
+			if session.Command != tt.command {
+				t.Errorf("session() command = %q, want %q", session.Command, tt.command)
+			}
+			// the guard and every helper, in --script-lib order, precede the script
+			want := testScripts[tt.script]
+			if len(tt.included) > 0 {
+				var body strings.Builder
+				body.WriteString(scriptLibGuard)
+				for _, lib := range tt.included {
+					body.WriteString("# --script-lib " + filepath.Join(dir, lib) + "\n" + testScripts[lib] + "\n")
+				}
+				body.WriteString("# --script " + filepath.Join(dir, tt.script) + "\n" + want)
+				want = body.String()
+			}
+			if got := string(session.Stdin); got != want {
+				t.Errorf("session() stdin = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestSessionRuns runs the session through a local bash the way sshd would, with the script on stdin
+func TestSessionRuns(t *testing.T) {
+	if _, lookErr := exec.LookPath("bash"); lookErr != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := scriptFiles(t, testScripts)
+	tests := []struct {
+		name   string
+		mode   string
+		script string
+		libs   string
+		want   string
+	}{
+		{name: "stdin", mode: scriptModeStdin, script: "deploy.sh", want: "deploy 1 web\n"},
+		{name: "upload", mode: scriptModeUpload, script: "deploy.sh", want: "deploy 1 web\n"},
+		{name: "stdin with libs", mode: scriptModeStdin, script: "plain.sh", libs: "guard.sh,lib/*.sh", want: "hello web\n"},
+		{name: "upload with libs", mode: scriptModeUpload, script: "plain.sh", libs: "guard.sh,lib/*.sh", want: "hello web\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, sessionErr := scriptConfig(dir, tt.mode, tt.script, "web", tt.libs).session()
+			if sessionErr != nil {
+				t.Fatal(sessionErr)
+			}
+			cmd := exec.Command("bash", "-c", session.Command)
+			cmd.Stdin = strings.NewReader(string(session.Stdin))
+			cmd.Env = append(os.Environ(), "TMPDIR="+t.TempDir())
+			out, runErr := cmd.Output()
+			if runErr != nil {
+				t.Fatalf("%s error = %v", session.Command, runErr)
+			}
+			if string(out) != tt.want {
+				t.Errorf("%s = %q, want %q", session.Command, out, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionErrors(t *testing.T) {
+	dir := scriptFiles(t, testScripts)
+	tests := []struct {
+		name   string
+		modify func(c *config)
+		want   string
+	}{
+		{name: "args without a script", modify: func(c *config) { *c.script, *c.bash = "", "uptime" }, want: "need a --script"},
+		{name: "bash and script", modify: func(c *config) { *c.bash = "uptime" }, want: "cannot be used together"},
+		{name: "pty", modify: func(c *config) { *c.pty = true }, want: "--pty cannot be used with --script"},
+		{name: "missing script", modify: func(c *config) { *c.script = filepath.Join(dir, "missing.sh") }, want: "failed to read --script"},
+		{name: "lib matching nothing", modify: func(c *config) { *c.scriptLib = filepath.Join(dir, "lib/*.bash") }, want: "matches no files"},
+		{name: "bad lib glob", modify: func(c *config) { *c.scriptLib = filepath.Join(dir, "lib/[") }, want: "invalid --script-lib glob"},
+		{name: "bad args", modify: func(c *config) { *c.scriptArgs = `"open` }, want: "invalid --script-args"},
+		{name: "mode", modify: func(c *config) { *c.scriptMode = "scp" }, want: "unsupported --script-mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := scriptConfig(dir, scriptModeStdin, "deploy.sh", "web", "")
+			tt.modify(c)
+			if _, sessionErr := c.session(); sessionErr == nil || !strings.Contains(sessionErr.Error(), tt.want) {
+				t.Errorf("session() error = %v, want %q", sessionErr, tt.want)
+			}
+		})
+	}
+}
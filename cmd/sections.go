@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSections are the nested config.yaml sections that configurable's flat keys cannot express
+type fileSections struct {
+	JumpHosts   []jumpHostSection  `yaml:"jump_hosts"`
+	HTTPBackend httpBackendSection `yaml:"http_backend"`
+}
+
+type jumpHostSection struct {
+	User    string `yaml:"user"`
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+}
+
+// httpBackendSection configures the terraform http backend, where the addresses are text/template
+// strings over .API, .ProjectID and .StateName, and the lock addresses may also use .Address
+type httpBackendSection struct {
+	Address              string `yaml:"address"`
+	LockAddress          string `yaml:"lock_address"`
+	UnlockAddress        string `yaml:"unlock_address"`
+	LockMethod           string `yaml:"lock_method"`
+	UnlockMethod         string `yaml:"unlock_method"`
+	Username             string `yaml:"username"`
+	RetryMax             *int   `yaml:"retry_max"`
+	RetryWaitMin         int    `yaml:"retry_wait_min"`
+	RetryWaitMax         int    `yaml:"retry_wait_max"`
+	SkipCertVerification bool   `yaml:"skip_cert_verification"`
+}
+
+func loadSections(configFile string) (fileSections, error) {
+	var sections fileSections
+	bytes, readErr := os.ReadFile(configFile)
+	if readErr != nil {
+		return sections, readErr
+	}
+	yamlErr := yaml.Unmarshal(bytes, &sections)
+	return sections, yamlErr
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/discovery"
+)
+
+const (
+	tfSourceCLI  = "cli"
+	tfSourceHTTP = "http"
+	tfSourceFile = "file"
+)
+
+const defaultStateFile = "terraform.tfstate"
+
+func (c *config) validateTFSource() error {
+	switch *c.tfSource {
+	case tfSourceCLI, tfSourceHTTP, tfSourceFile:
+	default:
+		return fmt.Errorf("unsupported --tf-source %s, valid options are: %s, %s, %s", *c.tfSource, tfSourceCLI, tfSourceHTTP, tfSourceFile)
+	}
+	// --tf-state on its own is enough to read a state file
+	if *c.tfSource == tfSourceCLI && len(*c.tfState) > 0 {
+		*c.tfSource = tfSourceFile
+	}
+	filter, filterErr := c.stateFilter()
+	if filterErr != nil {
+		return filterErr
+	}
+	if !c.isUsingState() && (len(filter.Resources) > 0 || len(filter.Tags) > 0) {
+		return fmt.Errorf("--tf-resource and --tf-tag need the state, set --tf-source %s or %s", tfSourceHTTP, tfSourceFile)
+	}
+	return nil
+}
+
+// isUsingState reads hosts from the terraform state itself rather than through the terraform CLI
+func (c *config) isUsingState() bool {
+	return *c.tfSource != tfSourceCLI
+}
+
+// stateFile is --tf-state, or the local state of --tfdir in the selected workspace
+func (c *config) stateFile() string {
+	if len(*c.tfState) > 0 {
+		return *c.tfState
+	}
+	if c.hasWorkspace() {
+		return filepath.Join(*c.tfDir, "terraform.tfstate.d", *c.workspace, defaultStateFile)
+	}
+	return filepath.Join(*c.tfDir, defaultStateFile)
+}
+
+// stateFilter parses --tf-resource and the key=glob pairs of --tf-tag
+func (c *config) stateFilter() (discovery.Filter, error) {
+	filter := discovery.Filter{Resources: splitCSV(*c.tfResource), Tags: make(map[string]string)}
+	for _, pair := range splitCSV(*c.tfTag) {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || len(key) == 0 {
+			return filter, fmt.Errorf("invalid --tf-tag entry %q, expected key=value", pair)
+		}
+		filter.Tags[key] = value
+	}
+	return filter, nil
+}
+
+// terraformState reads the state once, either from the same http backend the terraform CLI would
+// use or from a state file
+func (c *config) terraformState() (*discovery.State, error) {
+	if c.state != nil {
+		return c.state, nil
+	}
+	var raw []byte
+	if *c.tfSource == tfSourceFile {
+		var readErr error
+		raw, readErr = os.ReadFile(c.stateFile())
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read terraform state: %w", readErr)
+		}
+	} else {
+		var fetchErr error
+		raw, fetchErr = c.backend.state().FetchState(c.ctx)
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+	}
+	state, parseErr := discovery.ParseState(raw)
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	c.state = state
+	return state, nil
+}
+
+func (c *config) stateOutputJSON(name string) ([]byte, error) {
+	state, stateErr := c.terraformState()
+	if stateErr != nil {
+		return nil, stateErr
+	}
+	output, ok := state.Outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("terraform state has no output %s", name)
+	}
+	return output.Value, nil
+}
@@ -0,0 +1,264 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// archive is a tar of a local file or directory, built once and sent to every host. Sums holds the
+// sha256 of every regular file keyed by its name inside the archive.
+type archive struct {
+	data  []byte
+	sums  map[string]string
+	isDir bool
+}
+
+// newArchive tars source, naming a file name and the contents of a directory relative to it
+func newArchive(source, name string) (*archive, error) {
+	info, statErr := os.Stat(source)
+	if statErr != nil {
+		return nil, statErr
+	}
+	a := &archive{sums: make(map[string]string), isDir: info.IsDir()}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if !a.isDir {
+		if err := a.add(tw, source, name, info); err != nil {
+			return nil, err
+		}
+	} else {
+		walkErr := filepath.WalkDir(source, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, relErr := filepath.Rel(source, p)
+			if relErr != nil || rel == "." {
+				return relErr
+			}
+			entryInfo, infoErr := d.Info()
+			if infoErr != nil {
+				return infoErr
+			}
+			return a.add(tw, p, filepath.ToSlash(rel), entryInfo)
+		})
+		if walkErr != nil {
+			return nil, walkErr
+		}
+	}
+	if closeErr := tw.Close(); closeErr != nil {
+		return nil, closeErr
+	}
+	a.data = buf.Bytes()
+	return a, nil
+}
+
+func (a *archive) add(tw *tar.Writer, file, name string, info fs.FileInfo) error {
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var linkErr error
+		if link, linkErr = os.Readlink(file); linkErr != nil {
+			return linkErr
+		}
+	}
+	header, headerErr := tar.FileInfoHeader(info, link)
+	if headerErr != nil {
+		return headerErr
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	// the remote side owns what it extracts
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	if writeErr := tw.WriteHeader(header); writeErr != nil {
+		return writeErr
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, openErr := os.Open(file)
+	if openErr != nil {
+		return openErr
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	sum := sha256.New()
+	if _, copyErr := io.Copy(io.MultiWriter(tw, sum), f); copyErr != nil {
+		return copyErr
+	}
+	a.sums[name] = hex.EncodeToString(sum.Sum(nil))
+	return nil
+}
+
+// extract writes the entries of a tar named root or root/... to dest, keeping their modes and
+// modification times, and returns the sha256 of every regular file keyed by its entry name
+func extract(data []byte, root, dest string) (map[string]string, error) {
+	sums := make(map[string]string)
+	// directory modes are applied last, so a read-only directory still receives its entries
+	dirModes := make(map[string]fs.FileMode)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, nextErr := tr.Next()
+		if errors.Is(nextErr, io.EOF) {
+			for dir, mode := range dirModes {
+				if err := os.Chmod(dir, mode); err != nil {
+					return nil, err
+				}
+			}
+			return sums, nil
+		}
+		if nextErr != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", nextErr)
+		}
+		name := path.Clean(header.Name)
+		rel, inside := strings.CutPrefix(name, root)
+		if name != root && (!inside || !strings.HasPrefix(rel, "/")) {
+			return nil, fmt.Errorf("archive entry %s is outside of %s", header.Name, root)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+		if err := checkEntry(header, dest, target); err != nil {
+			return nil, fmt.Errorf("archive entry %s: %w", header.Name, err)
+		}
+		if err := write(tr, header, target); err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeSymlink {
+			if err := checkResolved(dest, target); err != nil {
+				return nil, fmt.Errorf("archive entry %s: %w", header.Name, err)
+			}
+		}
+		if header.Typeflag == tar.TypeDir {
+			dirModes[target] = header.FileInfo().Mode().Perm()
+		}
+		if header.Typeflag == tar.TypeReg {
+			sum, sumErr := fileSum(target)
+			if sumErr != nil {
+				return nil, sumErr
+			}
+			sums[name] = sum
+		}
+	}
+}
+
+// checkEntry keeps an archive from writing outside of dest through its own symlinks. Symlinks may
+// not be absolute or point outside of dest, and no entry is written through a symlink below dest,
+// short of a symlink that replaces another.
+func checkEntry(header *tar.Header, dest, target string) error {
+	isLink := header.Typeflag == tar.TypeSymlink
+	if isLink {
+		if filepath.IsAbs(header.Linkname) {
+			return fmt.Errorf("symlink to absolute path %s", header.Linkname)
+		}
+		base := dest
+		if target == dest {
+			base = filepath.Dir(dest)
+		}
+		if !within(base, filepath.Join(filepath.Dir(target), header.Linkname)) {
+			return fmt.Errorf("symlink to %s points outside of %s", header.Linkname, dest)
+		}
+	}
+	rel, relErr := filepath.Rel(dest, target)
+	if relErr != nil || rel == "." {
+		return relErr
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	current := dest
+	for i, part := range parts {
+		current = filepath.Join(current, part)
+		info, lstatErr := os.Lstat(current)
+		if errors.Is(lstatErr, fs.ErrNotExist) {
+			return nil
+		}
+		if lstatErr != nil {
+			return lstatErr
+		}
+		if info.Mode()&fs.ModeSymlink != 0 && !(isLink && i == len(parts)-1) {
+			return fmt.Errorf("%s is a symlink", current)
+		}
+	}
+	return nil
+}
+
+// checkResolved confirms that target, with every symlink resolved, is still inside of dest
+func checkResolved(dest, target string) error {
+	resolvedDest, destErr := filepath.EvalSymlinks(dest)
+	if destErr != nil {
+		return destErr
+	}
+	resolved, resolveErr := filepath.EvalSymlinks(target)
+	if resolveErr != nil {
+		return resolveErr
+	}
+	if !within(resolvedDest, resolved) {
+		return fmt.Errorf("%s resolves to %s, outside of %s", target, resolved, dest)
+	}
+	return nil
+}
+
+// within tells whether p is dir or below it
+func within(dir, p string) bool {
+	rel, relErr := filepath.Rel(dir, p)
+	return relErr == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func write(tr *tar.Reader, header *tar.Header, target string) error {
+	mode := header.FileInfo().Mode()
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, 0o755)
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		_ = os.Remove(target)
+		return os.Symlink(header.Linkname, target)
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		f, openErr := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+		if openErr != nil {
+			return openErr
+		}
+		_, copyErr := io.Copy(f, tr)
+		closeErr := f.Close()
+		if copyErr != nil {
+			return copyErr
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+		if err := os.Chmod(target, mode.Perm()); err != nil {
+			return err
+		}
+		return os.Chtimes(target, header.ModTime, header.ModTime)
+	default:
+		return nil
+	}
+}
+
+func fileSum(file string) (string, error) {
+	f, openErr := os.Open(file)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	sum := sha256.New()
+	if _, copyErr := io.Copy(sum, f); copyErr != nil {
+		return "", copyErr
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
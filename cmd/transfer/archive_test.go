@@ -0,0 +1,163 @@
+package transfer
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// entry is a tar entry, a symlink when link is set and a regular file otherwise
+type entry struct {
+	name string
+	link string
+	body string
+}
+
+func tarOf(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case len(e.link) > 0:
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.link, 0
+		case strings.HasSuffix(e.name, "/"):
+			header.Typeflag, header.Mode = tar.TypeDir, 0o755
+		}
+		if writeErr := tw.WriteHeader(header); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+		if _, writeErr := tw.Write([]byte(e.body)); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	if closeErr := tw.Close(); closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "logs")
+	data := tarOf(t,
+		entry{name: "logs/"},
+		entry{name: "logs/app/"},
+		entry{name: "logs/app/today.log", body: "hello\n"},
+		entry{name: "logs/latest", link: "app/today.log"},
+	)
+	sums, extractErr := extract(data, "logs", dest)
+	if extractErr != nil {
+		t.Fatalf("extract() error = %v", extractErr)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "latest")); string(got) != "hello\n" {
+		t.Errorf("latest = %q, want the linked log", got)
+	}
+	if len(sums) != 1 || len(sums["logs/app/today.log"]) != 64 {
+		t.Errorf("extract() sums = %v, want the sha256 of logs/app/today.log", sums)
+	}
+}
+
+func TestExtractEscapes(t *testing.T) {
+	tests := map[string][]entry{
+		"entry outside of root":  {{name: "other/evil", body: "x"}},
+		"parent directory entry": {{name: "logs/../evil", body: "x"}},
+		"absolute symlink":       {{name: "logs/link", link: "/tmp"}},
+		"escaping symlink":       {{name: "logs/link", link: "../../outside"}},
+		"file under a symlink": {
+			{name: "logs/sub/", body: ""},
+			{name: "logs/link", link: "sub"},
+			{name: "logs/link/evil", body: "x"},
+		},
+		"file over a symlink": {
+			{name: "logs/target", body: "keep"},
+			{name: "logs/link", link: "target"},
+			{name: "logs/link", body: "x"},
+		},
+	}
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "out", "logs")
+			if _, extractErr := extract(tarOf(t, entries...), "logs", dest); extractErr == nil {
+				t.Errorf("extract() error = nil, want an error")
+			}
+			if _, statErr := os.Lstat(filepath.Join(parent, "evil")); statErr == nil {
+				t.Errorf("extract() wrote outside of %s", dest)
+			}
+		})
+	}
+}
+
+// TestExtractThroughOutsideSymlink is the attack of a hostile host: a symlink out of dest followed
+// by a file written through it
+func TestExtractThroughOutsideSymlink(t *testing.T) {
+	outside := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "logs")
+	data := tarOf(t, entry{name: "logs/"}, entry{name: "logs/link", link: outside}, entry{name: "logs/link/evil", body: "x"})
+	if _, extractErr := extract(data, "logs", dest); extractErr == nil {
+		t.Fatalf("extract() error = nil, want an error")
+	}
+	if _, statErr := os.Stat(filepath.Join(outside, "evil")); statErr == nil {
+		t.Errorf("extract() wrote %s through the symlink", filepath.Join(outside, "evil"))
+	}
+}
+
+// sumTransport answers every session with stdout
+type sumTransport struct {
+	stdout string
+}
+
+func (s sumTransport) Name() string {
+	return "sums"
+}
+
+func (s sumTransport) Close() error {
+	return nil
+}
+
+func (s sumTransport) Run(context.Context, transport.Host, transport.Session) command.CommandOutput {
+	return command.CommandOutput{Stdout: []byte(s.stdout)}
+}
+
+func TestPutFileVerify(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "app.conf")
+	if writeErr := os.WriteFile(source, []byte("port = 80\n"), 0o644); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	dest, parseErr := fleet.ParseTemplate("dest", "/etc/app.conf")
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	put, putErr := NewPut(source, dest)
+	if putErr != nil {
+		t.Fatal(putErr)
+	}
+	sum := put.archive.sums["app.conf"]
+	tests := []struct {
+		name    string
+		stdout  string
+		wantErr bool
+	}{
+		{name: "verified", stdout: sum + "  /etc/app.conf\n"},
+		{name: "mismatch", stdout: strings.Repeat("0", 64) + "  /etc/app.conf\n", wantErr: true},
+		{name: "no checksum", stdout: "sha256sum: command not found\n", wantErr: true},
+		{name: "two checksums", stdout: sum + "  /etc/app.conf\n" + sum + "  /etc/other.conf\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := put.Run(context.Background(), sumTransport{stdout: tt.stdout}, transport.Host{Address: "10.0.0.1"}, transport.Session{})
+			if gotErr := output.Error != nil; gotErr != tt.wantErr {
+				t.Errorf("Run() error = %v, want error %v", output.Error, tt.wantErr)
+			}
+		})
+	}
+}
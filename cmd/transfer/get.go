@@ -0,0 +1,79 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// Get copies a remote file or directory from every host to a local Dest, which should differ per
+// host, such as logs/{{.Host}}/syslog. The host sends a tar on stdout and the sha256 of every file
+// on stderr, and each file is checked against its sum once extracted.
+type Get struct {
+	Source *fleet.Template
+	Dest   *fleet.Template
+}
+
+func (g *Get) Prepare(hosts []transport.Host) error {
+	if sourceErr := g.Source.Prepare(hosts); sourceErr != nil {
+		return sourceErr
+	}
+	return g.Dest.Prepare(hosts)
+}
+
+func (g *Get) Run(ctx context.Context, t transport.Transport, host transport.Host, session transport.Session) command.CommandOutput {
+	source, sourceErr := g.Source.Render(host)
+	if sourceErr != nil {
+		return failed("get", sourceErr)
+	}
+	source = remotePath(source)
+	dest, destErr := g.Dest.Render(host)
+	if destErr != nil {
+		return failed(fmt.Sprintf("get %s", source), destErr)
+	}
+	root := path.Base(source)
+	if root == "." || root == "/" {
+		return failed(fmt.Sprintf("get %s", source), fmt.Errorf("cannot get %s, name a file or directory inside of it", source))
+	}
+	if info, statErr := os.Stat(dest); statErr == nil && info.IsDir() {
+		dest = filepath.Join(dest, root)
+	}
+	description := fmt.Sprintf("get %s %s", source, dest)
+
+	// the tar must not reach a --stream or a pty
+	session.Command, session.PTY = g.command(source), false
+	session.Stdout, session.Stderr = nil, nil
+	output := t.Run(ctx, host, session)
+	output.Command = description
+	remoteSums, stderr := parseSums(output.Stderr)
+	output.Stderr = []byte(stderr)
+	if output.Error != nil || output.ExitCode != 0 {
+		output.Stdout = nil
+		return output
+	}
+	localSums, extractErr := extract(output.Stdout, root, dest)
+	output.Stdout = nil
+	if extractErr != nil {
+		output.Error = fmt.Errorf("failed to extract %s into %s: %w", source, dest, extractErr)
+		return output
+	}
+	local := func(entry string) string {
+		return filepath.Join(dest, filepath.FromSlash(strings.TrimPrefix(entry, root)))
+	}
+	report, verifyErr := verify(rekey(remoteSums, local), rekey(localSums, local))
+	output.Stdout, output.Error = []byte(report), verifyErr
+	return output
+}
+
+func (g *Get) command(source string) string {
+	base := command.Quote(path.Base(source))
+	return fmt.Sprintf("%s; set -e; cd %s; find %s -type f -exec $SUM {} + >&2; tar -cf - %s",
+		sumCmd, remoteDir(source), base, base)
+}
@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/fleet"
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/transport"
+)
+
+// Put copies a local file or directory to Dest on every host. It is sent as a tar over the
+// session's stdin, keeping file modes and modification times, and every file is checked against
+// its sha256 on the host afterwards. A file put onto an existing directory lands inside of it.
+type Put struct {
+	Source  string
+	Dest    *fleet.Template
+	entry   string
+	archive *archive
+}
+
+func NewPut(source string, dest *fleet.Template) (*Put, error) {
+	entry := filepath.Base(filepath.Clean(source))
+	a, archiveErr := newArchive(source, entry)
+	if archiveErr != nil {
+		return nil, fmt.Errorf("failed to archive %s: %w", source, archiveErr)
+	}
+	return &Put{Source: source, Dest: dest, entry: entry, archive: a}, nil
+}
+
+func (p *Put) Prepare(hosts []transport.Host) error {
+	return p.Dest.Prepare(hosts)
+}
+
+func (p *Put) Run(ctx context.Context, t transport.Transport, host transport.Host, session transport.Session) command.CommandOutput {
+	dest, renderErr := p.Dest.Render(host)
+	if renderErr != nil {
+		return failed(fmt.Sprintf("put %s", p.Source), renderErr)
+	}
+	dest = remotePath(dest)
+	description := fmt.Sprintf("put %s %s", p.Source, dest)
+	session.Command, session.Stdin, session.PTY = p.command(dest), p.archive.data, false
+	output := t.Run(ctx, host, session)
+	output.Command = description
+	if output.Error != nil || output.ExitCode != 0 {
+		return output
+	}
+	got, _ := parseSums(output.Stdout)
+	want := rekey(p.archive.sums, func(entry string) string {
+		return path.Join(dest, entry)
+	})
+	if !p.archive.isDir {
+		// the host reports where the file landed, which is inside dest when dest is a directory
+		if len(got) != 1 {
+			output.Error = fmt.Errorf("host reported %d checksums for %s, want 1", len(got), p.entry)
+			return output
+		}
+		want = make(map[string]string)
+		for file := range got {
+			want[file] = p.archive.sums[p.entry]
+		}
+	}
+	report, verifyErr := verify(want, got)
+	output.Stdout, output.Error = []byte(report), verifyErr
+	return output
+}
+
+func (p *Put) command(dest string) string {
+	quoted := command.Quote(dest)
+	if p.archive.isDir {
+		return fmt.Sprintf("%s; set -e; mkdir -p %s; tar -xpof - -C %s; find %s -type f -exec $SUM {} +",
+			sumCmd, quoted, quoted, quoted)
+	}
+	// extract next to dest first, so dest is only replaced by a complete file
+	return fmt.Sprintf(`%s; set -e; dest=%s; mkdir -p %s; if [ -d "$dest" ]; then dest="$dest"/%s; fi; `+
+		`tmp=$(mktemp -d "$(dirname "$dest")/.esb-put.XXXXXX"); trap 'rm -rf "$tmp"' EXIT; `+
+		`tar -xpof - -C "$tmp"; mv -f "$tmp"/%s "$dest"; $SUM "$dest"`,
+		sumCmd, quoted, remoteDir(dest), command.Quote(p.entry), command.Quote(p.entry))
+}
@@ -0,0 +1,83 @@
+package transfer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+)
+
+// sumCmd picks sha256sum from GNU coreutils or the shasum of macOS and BSD as $SUM
+const sumCmd = `if command -v sha256sum >/dev/null 2>&1; then SUM=sha256sum; else SUM="shasum -a 256"; fi`
+
+var sumLineRegex = regexp.MustCompile(`^([0-9a-f]{64}) [ *](.+)$`)
+
+// parseSums reads sha256sum lines into sums keyed by their cleaned path, and returns every other line
+func parseSums(out []byte) (map[string]string, string) {
+	sums := make(map[string]string)
+	var rest []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if match := sumLineRegex.FindStringSubmatch(line); match != nil {
+			sums[path.Clean(match[2])] = match[1]
+			continue
+		}
+		if len(line) > 0 {
+			rest = append(rest, line)
+		}
+	}
+	return sums, strings.Join(rest, "\n")
+}
+
+// verify compares the sums that were sent against the sums that arrived, both keyed by the path
+// of the copy, and reports one sha256sum style line per file
+func verify(want, got map[string]string) (string, error) {
+	files := make([]string, 0, len(want))
+	for file := range want {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	report := strings.Builder{}
+	var mismatched []string
+	for _, file := range files {
+		if got[file] != want[file] {
+			mismatched = append(mismatched, file)
+			report.WriteString(fmt.Sprintf("%s  %s (MISMATCH, arrived as %q)\n", want[file], file, got[file]))
+			continue
+		}
+		report.WriteString(fmt.Sprintf("%s  %s\n", want[file], file))
+	}
+	if len(mismatched) > 0 {
+		return report.String(), fmt.Errorf("checksum mismatch for %s", strings.Join(mismatched, ", "))
+	}
+	return report.String(), nil
+}
+
+// remotePath cleans a remote path, where ~/ is dropped since sessions start in the home directory
+func remotePath(p string) string {
+	p = path.Clean(p)
+	if p == "~" {
+		return "."
+	}
+	return strings.TrimPrefix(p, "~/")
+}
+
+// rekey maps the keys of sums through name
+func rekey(sums map[string]string, name func(string) string) map[string]string {
+	out := make(map[string]string, len(sums))
+	for key, sum := range sums {
+		out[name(key)] = sum
+	}
+	return out
+}
+
+func failed(description string, err error) command.CommandOutput {
+	return command.CommandOutput{Command: description, ExitCode: -1, Error: err}
+}
+
+// remoteDir is the directory of a remote path, quoted for the shell
+func remoteDir(p string) string {
+	return command.Quote(path.Dir(p))
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// PassphraseFunc returns the passphrase of the encrypted private key at path
+type PassphraseFunc func(path string) ([]byte, error)
+
+// Keyring holds the private keys and ssh-agent used to authenticate. Keys are parsed once and
+// cached, so an encrypted key only asks for its passphrase the first time it is needed.
+type Keyring struct {
+	mu         sync.Mutex
+	signers    map[string]ssh.Signer
+	passphrase PassphraseFunc
+	agent      agent.ExtendedAgent
+}
+
+// NewKeyring connects to the agent at SSH_AUTH_SOCK when useAgent is set and the socket exists. A
+// socket that cannot be reached, like a stale one left in a tmux session, is logged and the keys
+// are used without the agent.
+func NewKeyring(useAgent bool, passphrase PassphraseFunc) (*Keyring, error) {
+	k := &Keyring{signers: make(map[string]ssh.Signer), passphrase: passphrase}
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if !useAgent || len(socket) == 0 {
+		return k, nil
+	}
+	conn, dialErr := net.Dial("unix", socket)
+	if dialErr != nil {
+		log.Printf("failed to connect to ssh-agent at %s, continuing without it: %v", socket, dialErr)
+		return k, nil
+	}
+	k.agent = agent.NewClient(conn)
+	return k, nil
+}
+
+func (k *Keyring) HasAgent() bool {
+	return k != nil && k.agent != nil
+}
+
+func (k *Keyring) Signer(path string) (ssh.Signer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if signer, ok := k.signers[path]; ok {
+		return signer, nil
+	}
+	keyBytes, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read ssh key %s: %w", path, readErr)
+	}
+	signer, parseErr := ssh.ParsePrivateKey(keyBytes)
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(parseErr, &missingErr) {
+		if k.passphrase == nil {
+			return nil, fmt.Errorf("ssh key %s is encrypted and no passphrase is available", path)
+		}
+		passphrase, passErr := k.passphrase(path)
+		if passErr != nil {
+			return nil, passErr
+		}
+		signer, parseErr = ssh.ParsePrivateKeyWithPassphrase(keyBytes, passphrase)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse ssh key %s: %w", path, parseErr)
+	}
+	k.signers[path] = signer
+	return signer, nil
+}
+
+// Load parses paths up front. A missing key file is skipped when the agent can authenticate instead.
+func (k *Keyring) Load(paths []string) ([]string, error) {
+	var loaded []string
+	for _, path := range paths {
+		if _, statErr := os.Stat(path); os.IsNotExist(statErr) && k.HasAgent() {
+			log.Printf("ssh key %s does not exist, relying on ssh-agent", path)
+			continue
+		}
+		if _, err := k.Signer(path); err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, path)
+	}
+	if len(loaded) == 0 && !k.HasAgent() {
+		return nil, errors.New("no ssh keys could be loaded and no ssh-agent is available, set --key or SSH_AUTH_SOCK")
+	}
+	return loaded, nil
+}
+
+// AuthMethod offers the keys at paths first, then every key held by the agent. They share a
+// single publickey method because the ssh client only tries each method name once.
+func (k *Keyring) AuthMethod(paths []string) ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		for _, path := range paths {
+			signer, err := k.Signer(path)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+		}
+		if k.HasAgent() {
+			agentSigners, agentErr := k.agent.Signers()
+			if agentErr != nil {
+				return nil, fmt.Errorf("failed to list ssh-agent keys: %w", agentErr)
+			}
+			signers = append(signers, agentSigners...)
+		}
+		return signers, nil
+	})
+}
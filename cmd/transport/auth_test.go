@@ -0,0 +1,33 @@
+package transport
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewKeyringStaleAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	keyring, keyringErr := NewKeyring(true, nil)
+	if keyringErr != nil {
+		t.Fatalf("NewKeyring() error = %v, want the agent skipped", keyringErr)
+	}
+	if keyring.HasAgent() {
+		t.Error("HasAgent() = true for a socket that does not exist")
+	}
+}
+
+func TestKeyringLoadWithoutAgent(t *testing.T) {
+	server := startServer(t)
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "agent.sock"))
+	keyring, keyringErr := NewKeyring(true, nil)
+	if keyringErr != nil {
+		t.Fatal(keyringErr)
+	}
+	loaded, loadErr := keyring.Load([]string{server.keyFile})
+	if loadErr != nil || len(loaded) != 1 {
+		t.Fatalf("Load() = %v, %v, want the key file loaded", loaded, loadErr)
+	}
+	if _, missingErr := keyring.Load([]string{filepath.Join(t.TempDir(), "missing")}); missingErr == nil {
+		t.Error("Load() of a missing key without an agent error = nil, want an error")
+	}
+}
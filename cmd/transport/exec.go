@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	sema "github.com/andreimerlescu/go-sema"
+)
+
+// sshErrorExitCode is what the ssh binary exits with when it fails itself rather than relaying the remote status
+const sshErrorExitCode = 255
+
+// DefaultExecOptions keeps the ssh binary to the keys given with -i, it is dropped when an agent is in use
+const DefaultExecOptions = "-o IdentitiesOnly=yes"
+
+type ExecOptions struct {
+	Binary    string
+	Options   string
+	KeyFiles  []string
+	Directory string
+	Env       []string
+	Limit     sema.Semaphore
+	HostKeys  *HostKeys
+	Jumps     []Host
+}
+
+// controlPersist is how long, in seconds, an idle jump host connection stays open for the next target
+const controlPersist = 30
+
+// Exec is the Transport that shells out to the local ssh binary through command.Prompt(). Jump
+// hosts are connected once and shared by every target through OpenSSH connection multiplexing,
+// with a control socket per hop in controlDir, which is created for the first target.
+type Exec struct {
+	options     ExecOptions
+	controlOnce sync.Once
+	controlDir  string
+}
+
+func NewExec(options ExecOptions) *Exec {
+	if len(options.Binary) == 0 {
+		options.Binary = "ssh"
+	}
+	return &Exec{options: options}
+}
+
+func (t *Exec) Name() string {
+	return NameExec
+}
+
+// Close stops the shared jump host connections and removes their control sockets
+func (t *Exec) Close() error {
+	// no target ran when the directory was never created, which also keeps later targets from creating it
+	t.controlOnce.Do(func() {})
+	if len(t.controlDir) == 0 {
+		return nil
+	}
+	for _, hop := range t.options.Jumps {
+		args := append(t.connectArgs(hop, ""), t.controlOptions(), "-O exit", hop.Destination())
+		_, _ = command.Prompt().RunInside(context.Background(), strings.Join(args, " "), t.options.Limit, t.options.Directory, t.options.Env, func(co command.CommandOutput) bool {
+			return true
+		})
+	}
+	return os.RemoveAll(t.controlDir)
+}
+
+// controlOptions share one connection to a jump host between the ssh commands of every target
+func (t *Exec) controlOptions() string {
+	return fmt.Sprintf("-o ControlMaster=auto -o ControlPath=%s -o ControlPersist=%d",
+		command.Quote(filepath.Join(t.controlDir, "%C")), controlPersist)
+}
+
+func (t *Exec) compile(host Host, session Session, pinnedFile string) string {
+	args := t.connectArgs(host, t.options.HostKeys.ExecOptions(pinnedFile))
+	if len(t.options.Jumps) > 0 {
+		args = append(args, "-o", command.Quote("ProxyCommand="+t.proxyCommand(t.options.Jumps, host)))
+	}
+	if session.PTY {
+		args = append(args, "-tt")
+	}
+	args = append(args, host.Destination(), command.Quote(session.Command))
+	return strings.Join(args, " ")
+}
+
+// connectArgs are the ssh binary and the options that authenticate to and verify host
+func (t *Exec) connectArgs(host Host, hostKeyOptions string) []string {
+	args := []string{t.options.Binary}
+	for _, key := range append(append([]string{}, host.Keys...), t.options.KeyFiles...) {
+		args = append(args, "-i", command.Quote(key))
+	}
+	if len(t.options.Options) > 0 {
+		args = append(args, t.options.Options)
+	}
+	args = append(args, hostKeyOptions)
+	if host.Port != 0 && host.Port != DefaultPort {
+		args = append(args, "-p", fmt.Sprint(host.Port))
+	}
+	return args
+}
+
+// proxyCommand reaches to through the last of hops, which is itself reached through the hops before
+// it. ssh -J would be shorter, but OpenSSH does not pass -i or the host key options of the command
+// line on to jump hosts. Every ssh expands the % tokens of its ProxyCommand once, so each level
+// doubles the % of the levels inside it and names its target literally rather than as %h:%p.
+func (t *Exec) proxyCommand(hops []Host, to Host) string {
+	hop := hops[len(hops)-1]
+	args := t.connectArgs(hop, t.options.HostKeys.ExecOptions(""))
+	if len(t.controlDir) > 0 {
+		args = append(args, t.controlOptions())
+	}
+	if len(hops) > 1 {
+		args = append(args, "-o", command.Quote("ProxyCommand="+t.proxyCommand(hops[:len(hops)-1], hop)))
+	}
+	args = append(args, "-W", command.Quote(to.Addr()), hop.Destination())
+	return strings.ReplaceAll(strings.Join(args, " "), "%", "%%")
+}
+
+func (t *Exec) Run(ctx context.Context, host Host, session Session) command.CommandOutput {
+	var pinnedFile string
+	if len(host.HostKey) > 0 {
+		var pinErr error
+		pinnedFile, pinErr = PinnedFile(host)
+		if pinErr != nil {
+			return command.CommandOutput{Command: session.Command, ExitCode: -1, Error: fmt.Errorf("failed to pin host key for %s: %w", host, pinErr)}
+		}
+		defer func() {
+			_ = os.Remove(pinnedFile)
+		}()
+	}
+	if len(t.options.Jumps) > 0 {
+		t.controlOnce.Do(func() {
+			dir, dirErr := os.MkdirTemp("", "esb-mux-")
+			if dirErr != nil {
+				log.Printf("failed to create a directory for jump host control sockets, connecting to them per host: %v", dirErr)
+			}
+			t.controlDir = dir
+		})
+	}
+	cmd := t.compile(host, session, pinnedFile)
+	output, _ := command.Prompt().RunInsideWithInputStream(ctx, cmd, t.options.Limit, t.options.Directory, string(session.Stdin), t.options.Env, session.Stdout, session.Stderr, func(co command.CommandOutput) bool {
+		return true
+	})
+	if output.ExitCode > 0 && output.ExitCode != sshErrorExitCode {
+		output.Error = nil
+	}
+	return output
+}
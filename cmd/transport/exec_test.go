@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sema "github.com/andreimerlescu/go-sema"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// TestExecJumpChain runs the ssh binary through two jump hosts and checks that the --key and the
+// known_hosts file given on the command line were used for the hops as well as for the targets,
+// and that the targets share one connection to each jump host
+func TestExecJumpChain(t *testing.T) {
+	if _, lookErr := exec.LookPath("ssh"); lookErr != nil {
+		t.Skip("no ssh binary")
+	}
+	target, first, second := startServer(t), startServer(t), startServer(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	hostKeys, hostKeysErr := NewHostKeys(HostKeyTOFU, knownHostsFile)
+	if hostKeysErr != nil {
+		t.Fatal(hostKeysErr)
+	}
+	hops := []Host{first.host(t), second.host(t)}
+	remote := NewExec(ExecOptions{
+		Options:   "-F /dev/null -o BatchMode=yes " + DefaultExecOptions,
+		KeyFiles:  []string{target.keyFile},
+		Directory: t.TempDir(),
+		Env:       os.Environ(),
+		Limit:     sema.New(1),
+		HostKeys:  hostKeys,
+		Jumps:     hops,
+	})
+	other := startServer(t)
+	for _, server := range []*testServer{target, other} {
+		output := remote.Run(context.Background(), server.host(t), Session{Command: "echo through the bastion"})
+		if output.Error != nil || output.ExitCode != 0 {
+			t.Fatalf("Run() = exit %d, error %v, stderr %s", output.ExitCode, output.Error, output.Stderr)
+		}
+		if got := strings.TrimSpace(string(output.Stdout)); got != "echo through the bastion" {
+			t.Errorf("stdout = %q, want the echoed command", got)
+		}
+	}
+	// both targets share the connections to the jump hosts
+	for _, hop := range []*testServer{first, second} {
+		if got := hop.accepted.Load(); got != 1 {
+			t.Errorf("jump host %s accepted %d connections, want 1", hop.addr, got)
+		}
+	}
+	if closeErr := remote.Close(); closeErr != nil {
+		t.Errorf("Close() error = %v", closeErr)
+	}
+	if _, statErr := os.Stat(remote.controlDir); statErr == nil {
+		t.Errorf("Close() left the control sockets in %s", remote.controlDir)
+	}
+	recorded, readErr := os.ReadFile(knownHostsFile)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	for _, host := range append(hops, target.host(t)) {
+		if !strings.Contains(string(recorded), knownhosts.Normalize(host.Addr())+" ") {
+			t.Errorf("known_hosts does not record %s:\n%s", host.Addr(), recorded)
+		}
+	}
+}
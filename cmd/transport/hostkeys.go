@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	HostKeyStrict = "strict"
+	HostKeyTOFU   = "tofu"
+	HostKeyOff    = "off"
+)
+
+// HostKeys verifies the keys that remote hosts present. In strict mode every host must already be
+// in File, in tofu (trust on first use) mode unknown hosts are recorded into File, and off accepts
+// anything. A host with a pinned Host.HostKey must always present exactly that key.
+type HostKeys struct {
+	Mode string
+	File string
+	mu   sync.Mutex
+}
+
+func NewHostKeys(mode, file string) (*HostKeys, error) {
+	switch mode {
+	case HostKeyStrict, HostKeyTOFU, HostKeyOff:
+	default:
+		return nil, fmt.Errorf("unsupported host key mode %s, valid options are: %s, %s, %s", mode, HostKeyStrict, HostKeyTOFU, HostKeyOff)
+	}
+	if mode == HostKeyStrict {
+		if _, statErr := os.Stat(file); statErr != nil {
+			return nil, fmt.Errorf("strict host key checking needs a known_hosts file: %w", statErr)
+		}
+	}
+	return &HostKeys{Mode: mode, File: file}, nil
+}
+
+// Callback returns the ssh.HostKeyCallback that verifies host
+func (h *HostKeys) Callback(host Host) (ssh.HostKeyCallback, error) {
+	if len(host.HostKey) > 0 {
+		pinned, _, _, _, parseErr := ssh.ParseAuthorizedKey([]byte(host.HostKey))
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid pinned host key for %s: %w", host, parseErr)
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(key.Marshal(), pinned.Marshal()) {
+				return fmt.Errorf("host key mismatch for %s: presented %s %s, pinned %s %s",
+					hostname, key.Type(), ssh.FingerprintSHA256(key), pinned.Type(), ssh.FingerprintSHA256(pinned))
+			}
+			return nil
+		}, nil
+	}
+	if h == nil || h.Mode == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return h.check, nil
+}
+
+// Algorithms are the host key algorithms to negotiate with host, those of its pinned key or of its
+// known_hosts entries. Without them x/crypto/ssh prefers ECDSA over ED25519 and a host known by
+// its ed25519 key would present a different one and fail as a mismatch.
+func (h *HostKeys) Algorithms(host Host) []string {
+	if len(host.HostKey) > 0 {
+		pinned, _, _, _, parseErr := ssh.ParseAuthorizedKey([]byte(host.HostKey))
+		if parseErr != nil {
+			return nil
+		}
+		return keyAlgorithms(nil, pinned.Type())
+	}
+	if h == nil || h.Mode == HostKeyOff {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// a key that matches nothing makes knownhosts list every key it has for host
+	var keyErr *knownhosts.KeyError
+	if !errors.As(h.verify(host.Addr(), &net.TCPAddr{}, unknownKey), &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		algorithms = keyAlgorithms(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+// unknownKey is an all-zero ed25519 key that no host presents
+var unknownKey, _ = ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+// keyAlgorithms appends the signature algorithms of keyType that are not in algorithms yet
+func keyAlgorithms(algorithms []string, keyType string) []string {
+	candidates := []string{keyType}
+	if keyType == ssh.KeyAlgoRSA {
+		candidates = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	for _, candidate := range candidates {
+		if !slices.Contains(algorithms, candidate) {
+			algorithms = append(algorithms, candidate)
+		}
+	}
+	return algorithms
+}
+
+func (h *HostKeys) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	// the lock keeps concurrent first connections from recording the same host twice
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	verifyErr := h.verify(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case verifyErr == nil:
+		return nil
+	case errors.As(verifyErr, &keyErr) && len(keyErr.Want) > 0:
+		want := keyErr.Want[0]
+		return fmt.Errorf("host key mismatch for %s: presented %s %s, %s:%d expects %s %s",
+			hostname, key.Type(), ssh.FingerprintSHA256(key), want.Filename, want.Line, want.Key.Type(), ssh.FingerprintSHA256(want.Key))
+	case errors.As(verifyErr, &keyErr) && h.Mode == HostKeyTOFU:
+		return h.record(hostname, key)
+	case errors.As(verifyErr, &keyErr):
+		return fmt.Errorf("host key for %s (%s %s) is not in %s", hostname, key.Type(), ssh.FingerprintSHA256(key), h.File)
+	default:
+		return verifyErr
+	}
+}
+
+func (h *HostKeys) verify(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, statErr := os.Stat(h.File); os.IsNotExist(statErr) {
+		return &knownhosts.KeyError{}
+	}
+	callback, loadErr := knownhosts.New(h.File)
+	if loadErr != nil {
+		return fmt.Errorf("failed to load %s: %w", h.File, loadErr)
+	}
+	return callback(hostname, remote, key)
+}
+
+func (h *HostKeys) record(hostname string, key ssh.PublicKey) error {
+	if !command.CreateDirectory(filepath.Dir(h.File)) {
+		return fmt.Errorf("cannot create directory for %s", h.File)
+	}
+	f, openErr := os.OpenFile(h.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if openErr != nil {
+		return openErr
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, writeErr := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	return writeErr
+}
+
+// ExecOptions translates the mode into options for the ssh binary, pinnedFile holds a pinned key when set
+func (h *HostKeys) ExecOptions(pinnedFile string) string {
+	if len(pinnedFile) > 0 {
+		return fmt.Sprintf("-o StrictHostKeyChecking=yes -o UserKnownHostsFile=%s", command.Quote(pinnedFile))
+	}
+	if h == nil || h.Mode == HostKeyOff {
+		return "-o StrictHostKeyChecking=no -o CheckHostIP=no"
+	}
+	checking := "yes"
+	if h.Mode == HostKeyTOFU {
+		checking = "accept-new"
+	}
+	return fmt.Sprintf("-o StrictHostKeyChecking=%s -o UserKnownHostsFile=%s", checking, command.Quote(h.File))
+}
+
+// PinnedFile writes host's pinned key into a temporary known_hosts file for the ssh binary
+func PinnedFile(host Host) (string, error) {
+	f, createErr := os.CreateTemp("", "esb-known-hosts-*")
+	if createErr != nil {
+		return "", createErr
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	_, writeErr := fmt.Fprintf(f, "%s %s\n", knownhosts.Normalize(host.Addr()), strings.TrimSpace(host.HostKey))
+	if writeErr != nil {
+		_ = os.Remove(f.Name())
+		return "", writeErr
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepaliveRequest is the global request OpenSSH clients send to check that a server still answers
+const keepaliveRequest = "keepalive@openssh.com"
+
+// jumpChain is the ProxyJump chain of bastions that every target is reached through. The chain
+// is connected on first use and the last hop's client is shared by every target session, after a
+// keepalive shows it still answers, otherwise the chain is connected again. lock is a channel
+// rather than a mutex, so hosts waiting for another host to connect the chain give up at their
+// own deadline.
+type jumpChain struct {
+	hops    []Host
+	lock    chan struct{}
+	clients []*ssh.Client
+}
+
+func newJumpChain(hops []Host) *jumpChain {
+	return &jumpChain{hops: hops, lock: make(chan struct{}, 1)}
+}
+
+func (j *jumpChain) client(ctx context.Context, t *SSH) (*ssh.Client, error) {
+	if len(j.hops) == 0 {
+		return nil, nil
+	}
+	select {
+	case j.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-j.lock
+	}()
+	if len(j.clients) == len(j.hops) {
+		last := j.clients[len(j.clients)-1]
+		aliveErr := keepalive(ctx, last, t.options.DialTimeout)
+		if aliveErr == nil {
+			return last, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("jump host %s stopped answering, reconnecting: %v", JumpSpec(j.hops[len(j.hops)-1:]), aliveErr)
+		_ = j.closeClients()
+	}
+	var via *ssh.Client
+	for _, hop := range j.hops {
+		c, err := t.connect(ctx, via, hop)
+		if err != nil {
+			_ = j.closeClients()
+			return nil, fmt.Errorf("failed to connect to jump host %s: %w", JumpSpec([]Host{hop}), err)
+		}
+		j.clients = append(j.clients, c)
+		via = c
+	}
+	return via, nil
+}
+
+// keepalive sends a keepalive over client and waits up to timeout for the answer. A server that
+// does not know the request still answers it, with a failure.
+func keepalive(ctx context.Context, client *ssh.Client, timeout time.Duration) error {
+	answered := make(chan error, 1)
+	go func() {
+		_, _, sendErr := client.SendRequest(keepaliveRequest, true, nil)
+		answered <- sendErr
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case sendErr := <-answered:
+		return sendErr
+	case <-timer.C:
+		return fmt.Errorf("no answer to a keepalive in %v", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (j *jumpChain) close() error {
+	j.lock <- struct{}{}
+	defer func() {
+		<-j.lock
+	}()
+	return j.closeClients()
+}
+
+func (j *jumpChain) closeClients() error {
+	var errs []error
+	for i := len(j.clients) - 1; i >= 0; i-- {
+		if err := j.clients[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	j.clients = nil
+	return errors.Join(errs...)
+}
+
+// ParseHost parses [user@]address[:port], falling back to defaultUser when no user is given
+func ParseHost(spec, defaultUser string) (Host, error) {
+	host := Host{User: defaultUser}
+	address := spec
+	if user, rest, found := strings.Cut(spec, "@"); found {
+		host.User, address = user, rest
+	}
+	if h, p, splitErr := net.SplitHostPort(address); splitErr == nil {
+		port, portErr := strconv.Atoi(p)
+		if portErr != nil {
+			return Host{}, fmt.Errorf("invalid port in %s: %w", spec, portErr)
+		}
+		address, host.Port = h, port
+	}
+	if len(address) == 0 {
+		return Host{}, fmt.Errorf("missing address in %s", spec)
+	}
+	host.Address = address
+	return host, nil
+}
+
+// JumpSpec renders hops the way ssh -J expects them
+func JumpSpec(hops []Host) string {
+	specs := make([]string, 0, len(hops))
+	for _, hop := range hops {
+		spec := hop.Destination()
+		if hop.Port != 0 && hop.Port != DefaultPort {
+			spec = fmt.Sprintf("%s:%d", spec, hop.Port)
+		}
+		specs = append(specs, spec)
+	}
+	return strings.Join(specs, ",")
+}
@@ -0,0 +1,203 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultTerm        = "xterm"
+	defaultDialTimeout = 30 * time.Second
+)
+
+type SSHOptions struct {
+	KeyFiles    []string
+	Keyring     *Keyring
+	DialTimeout time.Duration
+	Term        string
+	HostKeys    *HostKeys
+	Jumps       []Host
+}
+
+// SSH is the in-process Transport built on golang.org/x/crypto/ssh
+type SSH struct {
+	options SSHOptions
+	keys    []string
+	jumps   *jumpChain
+}
+
+func NewSSH(options SSHOptions) (*SSH, error) {
+	if options.Keyring == nil {
+		options.Keyring = &Keyring{signers: make(map[string]ssh.Signer)}
+	}
+	keys, loadErr := options.Keyring.Load(options.KeyFiles)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	if len(options.Term) == 0 {
+		options.Term = defaultTerm
+	}
+	if options.DialTimeout == 0 {
+		options.DialTimeout = defaultDialTimeout
+	}
+	return &SSH{options: options, keys: keys, jumps: newJumpChain(options.Jumps)}, nil
+}
+
+func (t *SSH) Name() string {
+	return NameNative
+}
+
+func (t *SSH) Close() error {
+	return t.jumps.close()
+}
+
+func (t *SSH) clientConfig(host Host) (*ssh.ClientConfig, error) {
+	hostKeyCallback, hostKeyErr := t.options.HostKeys.Callback(host)
+	if hostKeyErr != nil {
+		return nil, hostKeyErr
+	}
+	return &ssh.ClientConfig{
+		User:              host.User,
+		Auth:              []ssh.AuthMethod{t.options.Keyring.AuthMethod(append(append([]string{}, host.Keys...), t.keys...))},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: t.options.HostKeys.Algorithms(host),
+		Timeout:           t.options.DialTimeout,
+	}, nil
+}
+
+func (t *SSH) dial(ctx context.Context, host Host) (*ssh.Client, error) {
+	via, jumpErr := t.jumps.client(ctx, t)
+	if jumpErr != nil {
+		return nil, jumpErr
+	}
+	return t.connect(ctx, via, host)
+}
+
+// connect opens an ssh.Client to host, tunneled through via when it is not nil
+func (t *SSH) connect(ctx context.Context, via *ssh.Client, host Host) (*ssh.Client, error) {
+	config, configErr := t.clientConfig(host)
+	if configErr != nil {
+		return nil, configErr
+	}
+	var (
+		conn    net.Conn
+		dialErr error
+	)
+	if via != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, t.options.DialTimeout)
+		defer cancel()
+		conn, dialErr = via.DialContext(dialCtx, "tcp", host.Addr())
+	} else {
+		dialer := net.Dialer{Timeout: t.options.DialTimeout}
+		conn, dialErr = dialer.DialContext(ctx, "tcp", host.Addr())
+	}
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	// the handshake does not take a context, so closing the conn is what unblocks it
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	clientConn, chans, reqs, handshakeErr := ssh.NewClientConn(conn, host.Addr(), config)
+	if !stop() {
+		if handshakeErr == nil {
+			_ = clientConn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if handshakeErr != nil {
+		_ = conn.Close()
+		return nil, handshakeErr
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+func (t *SSH) Run(ctx context.Context, host Host, session Session) command.CommandOutput {
+	var (
+		output  = command.CommandOutput{Command: session.Command, ExitCode: -1}
+		outBuff = bytes.Buffer{}
+		errBuff = bytes.Buffer{}
+		start   = time.Now().UTC()
+	)
+
+	client, dialErr := t.dial(ctx, host)
+	if dialErr != nil && ctx.Err() != nil {
+		dialErr = ctx.Err()
+	}
+	if dialErr != nil {
+		output.Error = fmt.Errorf("failed to connect to %s: %w", host.Addr(), dialErr)
+		output.Runtime = time.Since(start)
+		return output
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	sess, sessErr := client.NewSession()
+	if sessErr != nil {
+		output.Error = fmt.Errorf("failed to open session on %s: %w", host.Addr(), sessErr)
+		output.Runtime = time.Since(start)
+		return output
+	}
+	defer func() {
+		_ = sess.Close()
+	}()
+
+	if session.PTY {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if ptyErr := sess.RequestPty(t.options.Term, 40, 80, modes); ptyErr != nil {
+			output.Error = fmt.Errorf("failed to request pty on %s: %w", host.Addr(), ptyErr)
+			output.Runtime = time.Since(start)
+			return output
+		}
+	}
+	if len(session.Stdin) > 0 {
+		sess.Stdin = bytes.NewReader(session.Stdin)
+	}
+	sess.Stdout = tee(&outBuff, session.Stdout)
+	sess.Stderr = tee(&errBuff, session.Stderr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sess.Run(session.Command)
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		_ = sess.Signal(ssh.SIGKILL)
+		_ = client.Close()
+		<-done
+		runErr = ctx.Err()
+	case runErr = <-done:
+	}
+
+	output.Stdout = outBuff.Bytes()
+	output.Stderr = errBuff.Bytes()
+	output.ExitCode, output.Error = exitStatus(runErr)
+	output.Runtime = time.Since(start)
+	return output
+}
+
+// exitStatus separates a remote non-zero exit, which is a result, from a transport failure, which is an error
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	return -1, err
+}
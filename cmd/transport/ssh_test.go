@@ -0,0 +1,302 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an in-process sshd that echoes the command of every exec request back on stdout.
+// It counts the connections it accepted and can drop them all, like a restarted bastion.
+type testServer struct {
+	addr     string
+	keyFile  string
+	ed25519  ssh.PublicKey
+	ecdsa    ssh.PublicKey
+	accepted atomic.Int32
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+// drop closes every connection the server has accepted
+func (s *testServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.conns = nil
+}
+
+func newSigner(t *testing.T, key any) ssh.Signer {
+	t.Helper()
+	signer, signerErr := ssh.NewSignerFromKey(key)
+	if signerErr != nil {
+		t.Fatal(signerErr)
+	}
+	return signer
+}
+
+// startServer listens on localhost with both an ecdsa and an ed25519 host key, the way a stock
+// OpenSSH sshd does, and accepts the client key it writes to keyFile
+func startServer(t *testing.T) *testServer {
+	t.Helper()
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edSigner, ecSigner := newSigner(t, edKey), newSigner(t, ecKey)
+
+	_, clientKey, _ := ed25519.GenerateKey(rand.Reader)
+	block, marshalErr := ssh.MarshalPrivateKey(clientKey, "")
+	if marshalErr != nil {
+		t.Fatal(marshalErr)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	if writeErr := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(ecSigner)
+	config.AddHostKey(edSigner)
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	server := &testServer{addr: listener.Addr().String(), keyFile: keyFile, ed25519: edSigner.PublicKey(), ecdsa: ecSigner.PublicKey()}
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			server.accepted.Add(1)
+			server.mu.Lock()
+			server.conns = append(server.conns, conn)
+			server.mu.Unlock()
+			go serve(conn, config)
+		}
+	}()
+	return server
+}
+
+func serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, handshakeErr := ssh.NewServerConn(conn, config)
+	if handshakeErr != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go forward(newChannel)
+			continue
+		}
+		channel, requests, acceptErr := newChannel.Accept()
+		if acceptErr != nil {
+			continue
+		}
+		go func() {
+			defer func() {
+				_ = channel.Close()
+			}()
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+				_, _ = channel.Write([]byte(payload.Command + "\n"))
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			}
+		}()
+	}
+}
+
+// forward serves a direct-tcpip channel, which is how ssh -W and jump hosts reach the next host
+func forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if unmarshalErr := ssh.Unmarshal(newChannel.ExtraData(), &target); unmarshalErr != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, unmarshalErr.Error())
+		return
+	}
+	conn, dialErr := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+	if dialErr != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, dialErr.Error())
+		return
+	}
+	channel, requests, acceptErr := newChannel.Accept()
+	if acceptErr != nil {
+		_ = conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		_ = conn.Close()
+	}()
+	_, _ = io.Copy(channel, conn)
+	_ = channel.Close()
+}
+
+func (s *testServer) host(t *testing.T) Host {
+	t.Helper()
+	host, parseErr := ParseHost(s.addr, "tester")
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	return host
+}
+
+func runEcho(t *testing.T, server *testServer, hostKeys *HostKeys, host Host) error {
+	t.Helper()
+	remote, transportErr := NewSSH(SSHOptions{KeyFiles: []string{server.keyFile}, HostKeys: hostKeys})
+	if transportErr != nil {
+		t.Fatal(transportErr)
+	}
+	defer func() {
+		_ = remote.Close()
+	}()
+	output := remote.Run(context.Background(), host, Session{Command: "echo ok"})
+	if output.Error == nil && strings.TrimSpace(string(output.Stdout)) != "echo ok" {
+		t.Errorf("stdout = %q, want the echoed command", output.Stdout)
+	}
+	return output.Error
+}
+
+func TestSSHPinnedEd25519HostKey(t *testing.T) {
+	server := startServer(t)
+	host := server.host(t)
+	host.HostKey = string(ssh.MarshalAuthorizedKey(server.ed25519))
+	if runErr := runEcho(t, server, nil, host); runErr != nil {
+		t.Fatalf("Run() error = %v", runErr)
+	}
+}
+
+func TestSSHPinnedKeyMismatch(t *testing.T) {
+	server := startServer(t)
+	host := server.host(t)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	host.HostKey = string(ssh.MarshalAuthorizedKey(newSigner(t, otherKey).PublicKey()))
+	if runErr := runEcho(t, server, nil, host); runErr == nil || !strings.Contains(runErr.Error(), "host key mismatch") {
+		t.Fatalf("Run() error = %v, want a host key mismatch", runErr)
+	}
+}
+
+func TestSSHKnownHostsEd25519(t *testing.T) {
+	server := startServer(t)
+	host := server.host(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(host.Addr())}, server.ed25519)
+	if writeErr := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	hostKeys, hostKeysErr := NewHostKeys(HostKeyStrict, knownHostsFile)
+	if hostKeysErr != nil {
+		t.Fatal(hostKeysErr)
+	}
+	if runErr := runEcho(t, server, hostKeys, host); runErr != nil {
+		t.Fatalf("Run() error = %v", runErr)
+	}
+}
+
+func TestSSHTrustOnFirstUse(t *testing.T) {
+	server := startServer(t)
+	host := server.host(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	hostKeys, hostKeysErr := NewHostKeys(HostKeyTOFU, knownHostsFile)
+	if hostKeysErr != nil {
+		t.Fatal(hostKeysErr)
+	}
+	for i := 0; i < 2; i++ {
+		if runErr := runEcho(t, server, hostKeys, host); runErr != nil {
+			t.Fatalf("Run() %d error = %v", i+1, runErr)
+		}
+	}
+	recorded, readErr := os.ReadFile(knownHostsFile)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if lines := strings.Count(string(recorded), "\n"); lines != 1 {
+		t.Errorf("known_hosts has %d lines, want the host recorded once", lines)
+	}
+}
+
+func TestKeyAlgorithms(t *testing.T) {
+	got := keyAlgorithms(keyAlgorithms(nil, ssh.KeyAlgoED25519), ssh.KeyAlgoRSA)
+	want := []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("keyAlgorithms() = %v, want %v", got, want)
+	}
+}
+
+// TestSSHJumpReconnect shares one bastion connection between targets and connects to the bastion
+// again once the shared connection was dropped
+func TestSSHJumpReconnect(t *testing.T) {
+	target, bastion := startServer(t), startServer(t)
+	remote, transportErr := NewSSH(SSHOptions{KeyFiles: []string{target.keyFile}, Jumps: []Host{bastion.host(t)}})
+	if transportErr != nil {
+		t.Fatal(transportErr)
+	}
+	defer func() {
+		_ = remote.Close()
+	}()
+	run := func() {
+		t.Helper()
+		output := remote.Run(context.Background(), target.host(t), Session{Command: "echo ok"})
+		if output.Error != nil || strings.TrimSpace(string(output.Stdout)) != "echo ok" {
+			t.Fatalf("Run() = %q, error %v", output.Stdout, output.Error)
+		}
+	}
+	run()
+	run()
+	if got := bastion.accepted.Load(); got != 1 {
+		t.Errorf("bastion accepted %d connections for two targets, want 1", got)
+	}
+	bastion.drop()
+	run()
+	if got := bastion.accepted.Load(); got != 2 {
+		t.Errorf("bastion accepted %d connections, want a new one after it dropped the first", got)
+	}
+}
+
+// TestSSHJumpWaitDeadline gives up waiting for another host to connect the jump chain at the
+// deadline of the host that waits
+func TestSSHJumpWaitDeadline(t *testing.T) {
+	chain := newJumpChain([]Host{{Address: "bastion"}})
+	chain.lock <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, clientErr := chain.client(ctx, &SSH{}); clientErr != context.DeadlineExceeded {
+		t.Errorf("client() error = %v, want %v", clientErr, context.DeadlineExceeded)
+	}
+}
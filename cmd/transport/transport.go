@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/andreimerlescu/extra-ssh-bash/cmd/command"
+)
+
+const (
+	NameNative = "native"
+	NameExec   = "exec"
+)
+
+const DefaultPort = 22
+
+// Host is a single remote target that a Transport can open a session against. HostKey, in
+// authorized_keys format, pins the key the host must present and Keys are private key paths
+// tried before the transport's own keys. Cluster names the terraform directory and workspace the
+// host was discovered in, Index its position in discovery order, and Vars are the variables that
+// discovery knows about it, which per-host templates can refer to.
+type Host struct {
+	Label   string
+	Address string
+	Port    int
+	User    string
+	HostKey string
+	Keys    []string
+	Cluster string
+	Index   int
+	Vars    map[string]string
+}
+
+// Session describes the remote command to run on a Host. Stdout and Stderr, when set, receive
+// output as it arrives in addition to the buffered copy returned in command.CommandOutput.
+type Session struct {
+	Command string
+	Stdin   []byte
+	PTY     bool
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Transport executes a Session on a Host and reports the outcome as a command.CommandOutput
+type Transport interface {
+	Name() string
+	Run(ctx context.Context, host Host, session Session) command.CommandOutput
+	Close() error
+}
+
+func (h Host) String() string {
+	if len(h.Label) > 0 {
+		return h.Label
+	}
+	return h.Address
+}
+
+// Addr returns the host:port used to dial the Host
+func (h Host) Addr() string {
+	port := h.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(h.Address, strconv.Itoa(port))
+}
+
+// UniqueLabels relabels every host whose String is shared by another host as <label> (<address>),
+// since results are keyed by it. Hosts that still collide are the same host listed twice.
+func UniqueLabels(hosts []Host) error {
+	counts := make(map[string]int)
+	for _, host := range hosts {
+		counts[host.String()]++
+	}
+	seen := make(map[string]bool)
+	for i, host := range hosts {
+		if counts[host.String()] > 1 && len(host.Label) > 0 {
+			hosts[i].Label = fmt.Sprintf("%s (%s)", host.Label, host.Address)
+		}
+		if seen[hosts[i].String()] {
+			return fmt.Errorf("host %s is listed more than once", hosts[i])
+		}
+		seen[hosts[i].String()] = true
+	}
+	return nil
+}
+
+// Destination returns the user@address form that the ssh binary expects
+func (h Host) Destination() string {
+	if len(h.User) == 0 {
+		return h.Address
+	}
+	return fmt.Sprintf("%s@%s", h.User, h.Address)
+}
+
+// tee adds w alongside buffer when w is set
+func tee(buffer io.Writer, w io.Writer) io.Writer {
+	if w == nil {
+		return buffer
+	}
+	return io.MultiWriter(buffer, w)
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUniqueLabels(t *testing.T) {
+	hosts := []Host{
+		{Label: "web", Address: "10.0.0.1"},
+		{Label: "web", Address: "10.0.0.2"},
+		{Label: "db", Address: "10.0.0.3"},
+		{Address: "10.0.0.4"},
+	}
+	if labelsErr := UniqueLabels(hosts); labelsErr != nil {
+		t.Fatalf("UniqueLabels() error = %v", labelsErr)
+	}
+	names := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		names = append(names, host.String())
+	}
+	if got, want := strings.Join(names, ","), "web (10.0.0.1),web (10.0.0.2),db,10.0.0.4"; got != want {
+		t.Errorf("UniqueLabels() = %s, want %s", got, want)
+	}
+}
+
+func TestUniqueLabelsDuplicates(t *testing.T) {
+	duplicates := map[string][]Host{
+		"address":           {{Address: "10.0.0.1"}, {Address: "10.0.0.1"}},
+		"label and address": {{Label: "web", Address: "10.0.0.1"}, {Label: "web", Address: "10.0.0.1"}},
+	}
+	for name, hosts := range duplicates {
+		t.Run(name, func(t *testing.T) {
+			if labelsErr := UniqueLabels(hosts); labelsErr == nil {
+				t.Errorf("UniqueLabels(%v) error = nil, want an error", hosts)
+			}
+		})
+	}
+}
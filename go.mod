@@ -3,8 +3,12 @@ module github.com/andreimerlescu/extra-ssh-bash
 go 1.22.5
 
 require (
-	github.com/andreimerlescu/configurable v0.0.8 // indirect
-	github.com/andreimerlescu/go-sema v0.0.1 // indirect
-	github.com/go-ini/ini v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/andreimerlescu/configurable v0.0.8
+	github.com/andreimerlescu/go-sema v0.0.1
+	github.com/go-ini/ini v1.67.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/term v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.28.0 // indirect